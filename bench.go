@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	exporter "github.com/scraton/typesense_exporter/exporter"
+
+	flag "github.com/namsral/flag"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// latencyRecorder records how long requests to Typesense take, from sending the request until its
+// response body is closed.
+type latencyRecorder struct {
+	mtx       sync.Mutex
+	durations []time.Duration
+}
+
+func (r *latencyRecorder) record(d time.Duration) {
+	r.mtx.Lock()
+	r.durations = append(r.durations, d)
+	r.mtx.Unlock()
+}
+
+// Middleware returns an exporter.Middleware timing the requests sent through it.
+func (r *latencyRecorder) Middleware() exporter.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := next.RoundTrip(req)
+			if err != nil {
+				r.record(time.Since(start))
+				return nil, err
+			}
+			res.Body = &timedBody{ReadCloser: res.Body, start: start, recorder: r}
+			return res, nil
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type timedBody struct {
+	io.ReadCloser
+	start    time.Time
+	recorder *latencyRecorder
+	once     sync.Once
+}
+
+func (b *timedBody) Close() error {
+	b.once.Do(func() {
+		b.recorder.record(time.Since(b.start))
+	})
+	return b.ReadCloser.Close()
+}
+
+// runBench repeatedly gathers the metrics of the configured Typesense node for a while and writes
+// the exporter's resource usage and the upstream latencies to w, configured by args as passed to
+// the bench subcommand.
+func runBench(w io.Writer, args []string) error {
+	var (
+		typesenseURL     string
+		typesenseAPIKey  string
+		typesenseTimeout string
+		collectors       string
+		durationFlag     string
+		concurrency      int
+	)
+
+	fs := flag.NewFlagSet(name+" bench", flag.ContinueOnError)
+	fs.StringVar(&typesenseURL, "typesense-url", "http://localhost:8108", "HTTP API address for Typesense node")
+	fs.StringVar(&typesenseAPIKey, "typesense-api-key", "", "API key for typesense")
+	fs.StringVar(&typesenseTimeout, "typesense-timeout", "5s", "timeout for trying to get Typesense metrics")
+	fs.StringVar(&collectors, "collectors", "", "comma-separated collectors to run, defaults to the collectors enabled by default")
+	fs.StringVar(&durationFlag, "duration", "60s", "how long to run collection cycles for")
+	fs.IntVar(&concurrency, "concurrency", 8, "number of collection cycles running at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if typesenseAPIKey == "" {
+		return fmt.Errorf("no API key provided")
+	}
+	timeout, err := time.ParseDuration(typesenseTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse timeout: %s", err)
+	}
+	duration, err := time.ParseDuration(durationFlag)
+	if err != nil {
+		return fmt.Errorf("unable to parse duration: %s", err)
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+
+	recorder := &latencyRecorder{}
+	registry := prometheus.NewRegistry()
+	opts := []exporter.Option{
+		exporter.WithURL(typesenseURL),
+		exporter.WithAPIKey(typesenseAPIKey),
+		exporter.WithTimeout(timeout),
+		exporter.WithLogger(&log.Logger{Out: io.Discard, Formatter: new(log.TextFormatter), Level: log.PanicLevel}),
+		exporter.WithRegisterer(registry),
+		exporter.WithMiddleware(recorder.Middleware()),
+	}
+	if collectors != "" {
+		var names []string
+		for _, n := range strings.Split(collectors, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				names = append(names, n)
+			}
+		}
+		opts = append(opts, exporter.WithCollectors(names...))
+	}
+	if _, err := exporter.New(opts...); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	// Peak heap usage is sampled, as the heap shrinks again after garbage collections.
+	var peakHeap uint64
+	sampleDone := make(chan struct{})
+	go func() {
+		defer close(sampleDone)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peakHeap {
+				peakHeap = m.HeapInuse
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cpuBefore, cpuSupported := cpuTime()
+	start := time.Now()
+
+	var (
+		mtx     sync.Mutex
+		cycles  []time.Duration
+		failed  int
+		workers sync.WaitGroup
+	)
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for ctx.Err() == nil {
+				cycleStart := time.Now()
+				families, err := registry.Gather()
+				d := time.Since(cycleStart)
+
+				mtx.Lock()
+				cycles = append(cycles, d)
+				if err != nil || !scrapesSucceeded(families) {
+					failed++
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+
+	elapsed := time.Since(start)
+	cpuAfter, _ := cpuTime()
+	runtime.ReadMemStats(&after)
+	<-sampleDone
+
+	recorder.mtx.Lock()
+	upstream := append([]time.Duration(nil), recorder.durations...)
+	recorder.mtx.Unlock()
+
+	n := len(cycles)
+	if n == 0 {
+		return fmt.Errorf("no collection cycle finished within %s", duration)
+	}
+	perSecond := func(count int) float64 {
+		return float64(count) / elapsed.Seconds()
+	}
+	allocated := after.TotalAlloc - before.TotalAlloc
+	objects := after.Mallocs - before.Mallocs
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "target\t%s\n", typesenseURL)
+	fmt.Fprintf(tw, "duration\t%s, concurrency %d, GOMAXPROCS %d\n", elapsed.Round(time.Millisecond), concurrency, runtime.GOMAXPROCS(0))
+	fmt.Fprintf(tw, "cycles\t%d (%.1f/s), %d failed\n", n, perSecond(n), failed)
+	fmt.Fprintf(tw, "cycle latency\t%s\n", percentiles(cycles))
+	fmt.Fprintf(tw, "upstream requests\t%d (%.1f/s)\n", len(upstream), perSecond(len(upstream)))
+	if len(upstream) > 0 {
+		fmt.Fprintf(tw, "upstream latency\t%s\n", percentiles(upstream))
+	}
+	if cpuSupported {
+		user := cpuAfter.user - cpuBefore.user
+		system := cpuAfter.system - cpuBefore.system
+		fmt.Fprintf(tw, "cpu time\tuser %s, system %s (%.1f%% of one core, %s/cycle)\n",
+			user.Round(time.Millisecond),
+			system.Round(time.Millisecond),
+			100*(user+system).Seconds()/elapsed.Seconds(),
+			((user + system) / time.Duration(n)).Round(time.Microsecond),
+		)
+	}
+	fmt.Fprintf(tw, "allocated\t%s (%s/cycle), %d objects (%d/cycle)\n", formatBytes(allocated), formatBytes(allocated/uint64(n)), objects, objects/uint64(n))
+	fmt.Fprintf(tw, "gc cycles\t%d\n", after.NumGC-before.NumGC)
+	fmt.Fprintf(tw, "heap in use\t%s, peak %s\n", formatBytes(after.HeapInuse), formatBytes(peakHeap))
+	fmt.Fprintf(tw, "goroutines\t%d\n", runtime.NumGoroutine())
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed == n {
+		return fmt.Errorf("every collection cycle failed")
+	}
+	return nil
+}
+
+// scrapesSucceeded reports whether every collector of the gathered metrics scraped Typesense
+// successfully.
+func scrapesSucceeded(families []*dto.MetricFamily) bool {
+	for _, f := range families {
+		if f.GetName() != "typesense_exporter_scrape_success" {
+			continue
+		}
+		for _, m := range f.Metric {
+			if m.GetGauge().GetValue() == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// percentiles formats the 50th, 90th and 99th percentile and the maximum of durations.
+func percentiles(durations []time.Duration) string {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	round := func(d time.Duration) time.Duration {
+		return d.Round(time.Microsecond)
+	}
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s",
+		round(p(0.5)), round(p(0.9)), round(p(0.99)), round(sorted[len(sorted)-1]))
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+type cpuUsage struct {
+	user, system time.Duration
+}
+
+// cpuTime returns the CPU time used by the process so far.
+func cpuTime() (cpuUsage, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return cpuUsage{}, false
+	}
+	return cpuUsage{
+		user:   time.Duration(ru.Utime.Nano()),
+		system: time.Duration(ru.Stime.Nano()),
+	}, true
+}
@@ -0,0 +1,12 @@
+package main
+
+import "time"
+
+type cpuUsage struct {
+	user, system time.Duration
+}
+
+// cpuTime isn't supported on Windows, so the bench report leaves out the CPU time.
+func cpuTime() (cpuUsage, bool) {
+	return cpuUsage{}, false
+}
@@ -1,13 +1,10 @@
 package collector
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"context"
 	"net/url"
-	"path"
 	"strings"
+	"sync"
 	"time"
 
 	prometheus "github.com/prometheus/client_golang/prometheus"
@@ -24,81 +21,256 @@ type labeledValues struct {
 }
 
 type apiStat struct {
-	Type  prometheus.ValueType
-	Desc  *prometheus.Desc
-	Value func(resp apiStatsResponse) []labeledValues
+	Type prometheus.ValueType
+	Desc *prometheus.Desc
+	// Field is the per-endpoint stats.json field the stat is read from.
+	Field string
+	// Divisor converts the values of Field into the stat's unit.
+	Divisor float64
+	Value   func(resp APIStatsResponse) []labeledValues
 }
 
 type apiMetric struct {
-	Type  prometheus.ValueType
-	Desc  *prometheus.Desc
-	Value func(resp apiStatsResponse) float64
+	Type prometheus.ValueType
+	Desc *prometheus.Desc
+	// Name is the name of the metric within the subsystem, which the names of its sampled
+	// aggregates are derived from.
+	Name string
+	// Field is the stats.json field the metric is read from.
+	Field string
+	Value func(resp APIStatsResponse) float64
 }
 
-type apiStatEntry map[string]float64
+// APIStatEntry maps "<method> <endpoint>" keys of /stats.json to their values.
+type APIStatEntry map[string]float64
 
-type apiStatsResponse struct {
+// APIStatsResponse is the decoded response of /stats.json.
+type APIStatsResponse struct {
 	DeleteLatency           float64      `json:"delete_latency_ms"`
 	DeleteRequestsPerSecond float64      `json:"delete_requests_per_second"`
 	ImportLatency           float64      `json:"import_latency_ms"`
 	ImportRequestsPerSecond float64      `json:"import_requests_per_second"`
-	Latency                 apiStatEntry `json:"latency_ms"`
+	Latency                 APIStatEntry `json:"latency_ms"`
 	PendingWriteBatches     float64      `json:"pending_write_batches"`
-	RequestsPerSecond       apiStatEntry `json:"requests_per_second"`
+	RequestsPerSecond       APIStatEntry `json:"requests_per_second"`
 	SearchLatency           float64      `json:"search_latency_ms"`
 	SearchRequestsPerSecond float64      `json:"search_requests_per_second"`
 	TotalRequestsPerSecond  float64      `json:"total_requests_per_second"`
 	WriteLatency            float64      `json:"write_latency_ms"`
 	WriteRequestsPerSecond  float64      `json:"write_requests_per_second"`
+
+	fields fieldSet
+}
+
+// UnmarshalJSON implements json.Unmarshaler, recording which fields the server reported. Fields
+// older releases don't report are skipped rather than mapped, as none was renamed.
+func (r *APIStatsResponse) UnmarshalJSON(b []byte) error {
+	type plain APIStatsResponse
+	fields, err := decodeFields(b, (*plain)(r))
+	r.fields = fields
+	return err
 }
 
 type APIStats struct {
-	logger *log.Logger
-	client *http.Client
-	url    *url.URL
+	logger   *log.Logger
+	url      *url.URL
+	upstream *upstream
 
-	up                              prometheus.Gauge
-	totalScrapes, jsonParseFailures prometheus.Counter
+	*targetTracker
+
+	up prometheus.Gauge
 
 	metrics []*apiMetric
 	stats   []*apiStat
+	// compat and compatStats hold the descriptions of the compatibility series, if enabled.
+	compat      map[*apiMetric]*prometheus.Desc
+	compatStats map[*apiStat]*prometheus.Desc
+	// millis and millisStats hold the descriptions of latencies in milliseconds, if enabled.
+	// Without seconds, latencies are only exposed in milliseconds.
+	millis      map[*apiMetric]*prometheus.Desc
+	millisStats map[*apiStat]*prometheus.Desc
+	seconds     bool
+
+	sampleInterval time.Duration
+	active         func() bool
+	samplerOnce    sync.Once
+	samplesMtx     sync.Mutex
+	samples        map[*apiMetric]*sampleWindow
+	sampled        map[*apiMetric][]*prometheus.Desc
+
+	// done stops the sampler once the collector is closed.
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func splitStatKey(s string) (string, string) {
-	split := strings.Split(s, " ")
-	return split[0], split[1]
+// splitStatKey splits a "<method> <endpoint>" stats.json key. Surrounding and repeated spaces are
+// ignored, keys without a method are taken as an endpoint with an empty method, and spaces within
+// the endpoint are kept. Query strings are dropped, so they can't blow up the label cardinality.
+// Other keys can't be parsed and return false.
+func splitStatKey(s string) (string, string, bool) {
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		s = s[:i]
+	}
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 1 && strings.HasPrefix(fields[0], "/"):
+		return "", fields[0], true
+	case len(fields) >= 2 && isHTTPMethod(fields[0]):
+		return fields[0], strings.Join(fields[1:], " "), true
+	}
+	return "", "", false
+}
+
+// isHTTPMethod reports whether s looks like an HTTP method, i.e. consists of upper case letters.
+func isHTTPMethod(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// collectionsPrefix is the path prefix of endpoints operating on a collection.
+const collectionsPrefix = "/collections/"
+
+// templateCollection extracts the collection name from endpoints below /collections/<name>,
+// returning the endpoint with the name replaced by :collection. Other endpoints are returned as is
+// with an empty collection.
+func templateCollection(endpoint string) (string, string) {
+	if !strings.HasPrefix(endpoint, collectionsPrefix) {
+		return "", endpoint
+	}
+	name, rest := endpoint[len(collectionsPrefix):], ""
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+	if name == "" {
+		return "", endpoint
+	}
+	return name, collectionsPrefix + ":collection" + rest
+}
+
+// statKeyLabels returns the label values for a "<method> <endpoint>" stats.json key, including the
+// collection label if enabled, or false if the key can't be parsed.
+func statKeyLabels(cluster, key string, collectionLabel bool) ([]string, bool) {
+	method, endpoint, ok := splitStatKey(key)
+	if !ok {
+		return nil, false
+	}
+	if !collectionLabel {
+		return []string{cluster, method, endpoint}, true
+	}
+	collection, endpoint := templateCollection(endpoint)
+	return []string{cluster, collection, method, endpoint}, true
 }
 
-func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStats {
+// statEntryValues returns the values of a per-endpoint stats.json field. Keys which can't be parsed
+// are skipped and counted, rather than exposed with misleading labels.
+//
+// Keys which only differ in their query string or spacing end up with the same labels, so their
+// values are merged: rates are summed, while averages such as latencies are weighted by the
+// requests per second of each key in weights, falling back to the plain average if none has any.
+func statEntryValues(upstream *upstream, field string, entry APIStatEntry, average bool, weights APIStatEntry, collectionLabel bool) []labeledValues {
+	type merged struct {
+		labels   []string
+		sum      float64
+		count    float64
+		weighted float64
+		weight   float64
+	}
+
+	cluster := upstream.url.String()
+	var keys []string
+	byLabels := make(map[string]*merged, len(entry))
+	for key, val := range entry {
+		labels, ok := statKeyLabels(cluster, key, collectionLabel)
+		if !ok {
+			upstream.logger.WithFields(log.Fields{"field": field, "key": key}).Debugln("skipping unparseable stats key")
+			upstream.countUnparseableKey("/stats.json", field)
+			continue
+		}
+		labelsKey := strings.Join(labels, "\xff")
+		m, ok := byLabels[labelsKey]
+		if !ok {
+			m = &merged{labels: labels}
+			byLabels[labelsKey] = m
+			keys = append(keys, labelsKey)
+		}
+		m.sum += val
+		m.count++
+		m.weighted += val * weights[key]
+		m.weight += weights[key]
+	}
+
+	ret := make([]labeledValues, 0, len(keys))
+	for _, key := range keys {
+		m := byLabels[key]
+		value := m.sum
+		switch {
+		case average && m.weight > 0:
+			value = m.weighted / m.weight
+		case average:
+			value = m.sum / m.count
+		}
+		ret = append(ret, labeledValues{labels: m.labels, value: value})
+	}
+	return ret
+}
+
+func init() {
+	Register("api_stats", true, func(config Config) (Collector, error) {
+		return NewAPIStats(config), nil
+	})
+}
+
+// NewAPIStats creates a new APIStats
+func NewAPIStats(config Config) *APIStats {
 	subsystem := "api_stats"
+	url := config.URL
+
+	upstream := newUpstream(config)
+
+	collectionLabel := config.APIStatsCollectionLabel
+	statLabels := []string{"cluster", "method", "endpoint"}
+	if collectionLabel {
+		statLabels = []string{"cluster", "collection", "method", "endpoint"}
+	}
+
+	c := &APIStats{
+		logger:   config.Logger,
+		url:      url,
+		upstream: upstream,
+
+		targetTracker: newTargetTracker(subsystem, upstream.endpointURL("/stats.json"), url),
 
-	return &APIStats{
-		logger: logger,
-		client: client,
-		url:    url,
+		seconds: config.LatencyUnit != LatencyUnitMilliseconds,
+
+		sampleInterval: config.APIStatsSampleInterval,
+		active:         config.Active,
+		done:           make(chan struct{}),
+		samples:        make(map[*apiMetric]*sampleWindow),
+		sampled:        make(map[*apiMetric][]*prometheus.Desc),
 
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: prometheus.BuildFQName(namespace, subsystem, "up"),
 			Help: "Was the last scrape of the Typesense stats.json endpoint successful",
 		}),
-		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: prometheus.BuildFQName(namespace, subsystem, "total_scrapes"),
-			Help: "Current total Typesense API stats scrapes",
-		}),
-		jsonParseFailures: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: prometheus.BuildFQName(namespace, subsystem, "json_parse_failures"),
-			Help: "Number of errors while parsing JSON",
-		}),
 
 		metrics: []*apiMetric{
 			{
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "delete_latency_seconds"),
-					"Latency for delete requests in seconds",
+					"Latency for delete requests in seconds, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "delete_latency_seconds",
+				Field: "delete_latency_ms",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.DeleteLatency) / 1000.0
 				},
 			},
@@ -106,10 +278,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "delete_requests_per_second"),
-					"Requests per second for deletions",
+					"Requests per second for deletions, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "delete_requests_per_second",
+				Field: "delete_requests_per_second",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.DeleteRequestsPerSecond)
 				},
 			},
@@ -117,10 +291,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "import_latency_seconds"),
-					"Latency for import requests in seconds",
+					"Latency for import requests in seconds, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "import_latency_seconds",
+				Field: "import_latency_ms",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.ImportLatency) / 1000.0
 				},
 			},
@@ -128,10 +304,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "import_requests_per_second"),
-					"Requests per second for imports",
+					"Requests per second for imports, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "import_requests_per_second",
+				Field: "import_requests_per_second",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.ImportRequestsPerSecond)
 				},
 			},
@@ -139,10 +317,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "pending_write_batches"),
-					"Pending write batches",
+					"Number of write batches waiting to be applied, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "pending_write_batches",
+				Field: "pending_write_batches",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.PendingWriteBatches)
 				},
 			},
@@ -150,10 +330,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "search_latency_seconds"),
-					"Latency for search requests",
+					"Latency for search requests in seconds, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "search_latency_seconds",
+				Field: "search_latency_ms",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.SearchLatency) / 1000.0
 				},
 			},
@@ -161,10 +343,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "search_requests_per_second"),
-					"Requests per second for searches",
+					"Requests per second for searches, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "search_requests_per_second",
+				Field: "search_requests_per_second",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.SearchRequestsPerSecond)
 				},
 			},
@@ -172,10 +356,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "total_requests_per_second"),
-					"Requests per second for all endpoints",
+					"Requests per second for all endpoints, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "total_requests_per_second",
+				Field: "total_requests_per_second",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.TotalRequestsPerSecond)
 				},
 			},
@@ -183,10 +369,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "write_latency_seconds"),
-					"Latency for write requests",
+					"Latency for write requests in seconds, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "write_latency_seconds",
+				Field: "write_latency_ms",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.WriteLatency) / 1000.0
 				},
 			},
@@ -194,10 +382,12 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "write_requests_per_second"),
-					"Requets per second for writes",
+					"Requests per second for writes, from /stats.json",
 					defaultAPIStatsLabels, nil,
 				),
-				Value: func(resp apiStatsResponse) float64 {
+				Name:  "write_requests_per_second",
+				Field: "write_requests_per_second",
+				Value: func(resp APIStatsResponse) float64 {
 					return float64(resp.WriteRequestsPerSecond)
 				},
 			},
@@ -207,127 +397,204 @@ func NewAPIStats(logger *log.Logger, client *http.Client, url *url.URL) *APIStat
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "latency_seconds"),
-					"Latency for each method and endpoint",
-					[]string{"cluster", "method", "endpoint"},
+					"Latency for requests in seconds by method and endpoint, from /stats.json",
+					statLabels,
 					nil,
 				),
-				Value: func(resp apiStatsResponse) []labeledValues {
-					ret := make([]labeledValues, 0, len(resp.Latency))
-					for key, val := range resp.Latency {
-						method, endpoint := splitStatKey(key)
-						ret = append(ret, labeledValues{
-							labels: []string{url.String(), method, endpoint},
-							value:  float64(val) / 1000.0,
-						})
-					}
-					return ret
+				Field:   "latency_ms",
+				Divisor: 1000.0,
+				Value: func(resp APIStatsResponse) []labeledValues {
+					return statEntryValues(upstream, "latency_ms", resp.Latency, true, resp.RequestsPerSecond, collectionLabel)
 				},
 			},
 			{
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "requests_per_second"),
-					"Requests per second for each method and endpoint",
-					[]string{"cluster", "method", "endpoint"},
+					"Requests per second by method and endpoint, from /stats.json",
+					statLabels,
 					nil,
 				),
-				Value: func(resp apiStatsResponse) []labeledValues {
-					ret := make([]labeledValues, 0, len(resp.RequestsPerSecond))
-					for key, val := range resp.RequestsPerSecond {
-						method, endpoint := splitStatKey(key)
-						ret = append(ret, labeledValues{
-							labels: []string{url.String(), method, endpoint},
-							value:  val,
-						})
-					}
-					return ret
+				Field:   "requests_per_second",
+				Divisor: 1,
+				Value: func(resp APIStatsResponse) []labeledValues {
+					return statEntryValues(upstream, "requests_per_second", resp.RequestsPerSecond, false, nil, collectionLabel)
 				},
 			},
 		},
 	}
+
+	if c.sampleInterval > 0 {
+		for _, metric := range c.metrics {
+			c.sampled[metric] = newSampledDescs(subsystem, metric)
+		}
+	}
+
+	if config.LatencyUnit == LatencyUnitMilliseconds || config.LatencyUnit == LatencyUnitBoth {
+		c.millis = make(map[*apiMetric]*prometheus.Desc)
+		for _, metric := range c.metrics {
+			if isLatencyField(metric.Field) {
+				c.millis[metric] = newMillisDesc(subsystem, metric.Field, defaultAPIStatsLabels)
+			}
+		}
+		c.millisStats = make(map[*apiStat]*prometheus.Desc)
+		for _, stat := range c.stats {
+			if isLatencyField(stat.Field) {
+				c.millisStats[stat] = newMillisDesc(subsystem, stat.Field, statLabels)
+			}
+		}
+	}
+
+	if config.CompatNames {
+		c.compat = make(map[*apiMetric]*prometheus.Desc, len(c.metrics))
+		for _, metric := range c.metrics {
+			c.compat[metric] = newCompatDesc(metric.Field, "/stats.json", defaultAPIStatsLabels)
+		}
+		c.compatStats = make(map[*apiStat]*prometheus.Desc, len(c.stats))
+		for _, stat := range c.stats {
+			c.compatStats[stat] = newCompatDesc(stat.Field, "/stats.json", statLabels)
+		}
+	}
+	return c
+}
+
+// MinServerVersion implements VersionedCollector; /stats.json was added in Typesense 0.19.0.
+func (c *APIStats) MinServerVersion() string {
+	return "0.19.0"
+}
+
+func (c *APIStats) upGauge() prometheus.Gauge {
+	return c.up
 }
 
 // Describe set Prometheus metrics descriptions.
 func (c *APIStats) Describe(ch chan<- *prometheus.Desc) {
 	for _, metric := range c.metrics {
-		ch <- metric.Desc
+		if c.exposed(metric.Field) {
+			ch <- metric.Desc
+		}
+	}
+	for _, stat := range c.stats {
+		if c.exposed(stat.Field) {
+			ch <- stat.Desc
+		}
+	}
+	for _, desc := range c.millis {
+		ch <- desc
+	}
+	for _, desc := range c.millisStats {
+		ch <- desc
+	}
+	for _, descs := range c.sampled {
+		for _, desc := range descs {
+			ch <- desc
+		}
+	}
+	for _, desc := range c.compat {
+		ch <- desc
+	}
+	for _, desc := range c.compatStats {
+		ch <- desc
 	}
 
 	ch <- c.up.Desc()
-	ch <- c.totalScrapes.Desc()
-	ch <- c.jsonParseFailures.Desc()
 }
 
-// Collect collects APIStats metrics.
-func (c *APIStats) Collect(ch chan<- prometheus.Metric) {
-	var err error
-	c.totalScrapes.Inc()
+// Update implements the Collector interface.
+func (c *APIStats) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
 	defer func() {
 		ch <- c.up
-		ch <- c.totalScrapes
-		ch <- c.jsonParseFailures
 	}()
 
 	start := time.Now()
-	resp, err := c.fetchAndDecodeAPIStats()
+	resp, err := c.fetchAndDecodeAPIStats(ctx)
+	c.report(start, err)
 	if err != nil {
 		c.up.Set(0)
-		c.logger.WithError(err).Warnln("failed to fetch and decode API stats")
-		return
+		return err
 	}
 	c.up.Set(1)
+	c.upstream.markSuccess("api_stats")
 
 	c.logger.WithField("duration", time.Since(start)).Debugln("fetched API stats successfully")
 
+	var samples map[*apiMetric]*sampleWindow
+	if c.sampleInterval > 0 {
+		c.samplerOnce.Do(func() {
+			go c.sample()
+		})
+		c.addSample(resp)
+		samples = c.takeSamples()
+	}
+
 	for _, metric := range c.metrics {
-		ch <- prometheus.MustNewConstMetric(
-			metric.Desc,
-			metric.Type,
-			metric.Value(resp),
-			c.url.String(),
-		)
+		if !resp.fields.has(metric.Field) {
+			c.logger.WithField("field", metric.Field).Debugln("field not reported by Typesense, skipping")
+			continue
+		}
+		if c.exposed(metric.Field) {
+			ch <- prometheus.MustNewConstMetric(
+				metric.Desc,
+				metric.Type,
+				metric.Value(resp),
+				c.url.String(),
+			)
+		}
+		if desc, ok := c.millis[metric]; ok {
+			if v, ok := rawFieldValue(resp, metric.Field); ok {
+				ch <- prometheus.MustNewConstMetric(desc, metric.Type, v, c.url.String())
+			}
+		}
+		if desc, ok := c.compat[metric]; ok {
+			if v, ok := rawFieldValue(resp, metric.Field); ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, c.url.String())
+			}
+		}
+		if w, ok := samples[metric]; ok {
+			for i, v := range w.values() {
+				ch <- prometheus.MustNewConstMetric(c.sampled[metric][i], prometheus.GaugeValue, v, c.url.String())
+			}
+		}
 	}
 
 	for _, stat := range c.stats {
+		compat, ok := c.compatStats[stat]
+		millis, hasMillis := c.millisStats[stat]
+		exposed := c.exposed(stat.Field)
 		for _, v := range stat.Value(resp) {
-			ch <- prometheus.MustNewConstMetric(
-				stat.Desc,
-				stat.Type,
-				v.value,
-				v.labels...,
-			)
+			if exposed {
+				ch <- prometheus.MustNewConstMetric(
+					stat.Desc,
+					stat.Type,
+					v.value/stat.Divisor,
+					v.labels...,
+				)
+			}
+			if hasMillis {
+				ch <- prometheus.MustNewConstMetric(millis, stat.Type, v.value, v.labels...)
+			}
+			if ok {
+				ch <- prometheus.MustNewConstMetric(compat, prometheus.GaugeValue, v.value, v.labels...)
+			}
 		}
 	}
-}
 
-func (c *APIStats) fetchAndDecodeAPIStats() (apiStatsResponse, error) {
-	var resp apiStatsResponse
+	return nil
+}
 
-	u := *c.url
-	u.Path = path.Join(u.Path, "/stats.json")
-	res, err := c.client.Get(u.String())
-	if err != nil {
-		return resp, fmt.Errorf("failed to get API stats from %s: %s", u.String(), err)
-	}
-	defer func() {
-		if err := res.Body.Close(); err != nil {
-			c.logger.WithError(err).Warnln("failed to close http.Client")
-		}
-	}()
+// exposed reports whether the metric read from the field is exposed, which latencies in seconds
+// aren't when they are only exposed in milliseconds.
+func (c *APIStats) exposed(field string) bool {
+	return c.seconds || !isLatencyField(field)
+}
 
-	if res.StatusCode != http.StatusOK {
-		return resp, fmt.Errorf("HTTP request failed with code %d", res.StatusCode)
-	}
+func (c *APIStats) fetchAndDecodeAPIStats(ctx context.Context) (APIStatsResponse, error) {
+	var resp APIStatsResponse
 
-	bts, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		c.jsonParseFailures.Inc()
-		return resp, err
-	}
-	if err := json.Unmarshal(bts, &resp); err != nil {
-		c.jsonParseFailures.Inc()
-		return resp, err
+	bts, err := c.upstream.fetchJSON(ctx, "/stats.json", &resp, c.recordingPayloads())
+	if bts != nil {
+		c.recordPayload(bts)
 	}
 
-	return resp, nil
+	return resp, err
 }
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// sampleAggregations are the aggregates exposed for the sampled API stats, in the order of
+// sampleWindow.values.
+var sampleAggregations = []string{"min", "max", "avg"}
+
+// sampleWindow aggregates the values an API stat took between two scrapes.
+type sampleWindow struct {
+	min, max, sum float64
+	count         int
+}
+
+func (w *sampleWindow) add(v float64) {
+	if w.count == 0 || v < w.min {
+		w.min = v
+	}
+	if w.count == 0 || v > w.max {
+		w.max = v
+	}
+	w.sum += v
+	w.count++
+}
+
+func (w *sampleWindow) values() []float64 {
+	return []float64{w.min, w.max, w.sum / float64(w.count)}
+}
+
+// newSampledDescs creates the descriptors of the min, max and avg aggregates of metric.
+func newSampledDescs(subsystem string, metric *apiMetric) []*prometheus.Desc {
+	descs := make([]*prometheus.Desc, 0, len(sampleAggregations))
+	for _, agg := range sampleAggregations {
+		descs = append(descs, prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, metric.Name+"_"+agg),
+			"The "+agg+" of "+metric.Name+" sampled from /stats.json since the last scrape",
+			defaultAPIStatsLabels, nil,
+		))
+	}
+	return descs
+}
+
+// sample fetches /stats.json every sample interval, adding the stats to the current window. It runs
+// from the first scrape of the collector until it is closed, skipping ticks while not active.
+func (c *APIStats) sample() {
+	ticker := time.NewTicker(c.sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if c.active != nil && !c.active() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.sampleInterval)
+		var resp APIStatsResponse
+		_, err := c.upstream.fetchJSON(ctx, "/stats.json", &resp, false)
+		cancel()
+		if err != nil {
+			c.logger.WithError(err).Debugln("failed to sample API stats")
+			continue
+		}
+		c.addSample(resp)
+	}
+}
+
+// Close stops sampling /stats.json.
+func (c *APIStats) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *APIStats) addSample(resp APIStatsResponse) {
+	c.samplesMtx.Lock()
+	defer c.samplesMtx.Unlock()
+
+	for _, metric := range c.metrics {
+		if !resp.fields.has(metric.Field) {
+			continue
+		}
+		w, ok := c.samples[metric]
+		if !ok {
+			w = &sampleWindow{}
+			c.samples[metric] = w
+		}
+		w.add(metric.Value(resp))
+	}
+}
+
+// takeSamples returns the current window and starts a new one.
+func (c *APIStats) takeSamples() map[*apiMetric]*sampleWindow {
+	c.samplesMtx.Lock()
+	defer c.samplesMtx.Unlock()
+
+	samples := c.samples
+	c.samples = make(map[*apiMetric]*sampleWindow, len(c.metrics))
+	return samples
+}
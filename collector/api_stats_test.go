@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSplitStatKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		method   string
+		endpoint string
+		ok       bool
+	}{
+		{key: "GET /collections", method: "GET", endpoint: "/collections", ok: true},
+		{key: "POST /collections/products/documents/search", method: "POST", endpoint: "/collections/products/documents/search", ok: true},
+		{key: "/health", endpoint: "/health", ok: true},
+		{key: "  GET   /collections  ", method: "GET", endpoint: "/collections", ok: true},
+		{key: "GET\t/collections", method: "GET", endpoint: "/collections", ok: true},
+		{key: "GET /collections/my collection/documents", method: "GET", endpoint: "/collections/my collection/documents", ok: true},
+		{key: "GET /collections/products/documents/search?q=shoes&query_by=name", method: "GET", endpoint: "/collections/products/documents/search", ok: true},
+		{key: "/collections?limit=10", endpoint: "/collections", ok: true},
+		{key: "GET /multi_search?", method: "GET", endpoint: "/multi_search", ok: true},
+		{key: ""},
+		{key: "   "},
+		{key: "GET"},
+		{key: "get /collections"},
+		{key: "collections"},
+		{key: "GET/collections"},
+		{key: "?q=shoes"},
+	}
+
+	for _, tt := range tests {
+		method, endpoint, ok := splitStatKey(tt.key)
+		if method != tt.method || endpoint != tt.endpoint || ok != tt.ok {
+			t.Errorf("splitStatKey(%q) = %q, %q, %v, want %q, %q, %v", tt.key, method, endpoint, ok, tt.method, tt.endpoint, tt.ok)
+		}
+	}
+}
+
+func TestStatKeyLabelsCollection(t *testing.T) {
+	tests := []struct {
+		key    string
+		labels []string
+		ok     bool
+	}{
+		{key: "GET /collections", labels: []string{"c", "", "GET", "/collections"}, ok: true},
+		{key: "GET /collections/products", labels: []string{"c", "products", "GET", "/collections/:collection"}, ok: true},
+		{key: "POST /collections/products/documents/search?q=a", labels: []string{"c", "products", "POST", "/collections/:collection/documents/search"}, ok: true},
+		{key: "DELETE /collections/", labels: []string{"c", "", "DELETE", "/collections/"}, ok: true},
+		{key: "GET"},
+	}
+
+	for _, tt := range tests {
+		labels, ok := statKeyLabels("c", tt.key, true)
+		if !reflect.DeepEqual(labels, tt.labels) || ok != tt.ok {
+			t.Errorf("statKeyLabels(%q) = %q, %v, want %q, %v", tt.key, labels, ok, tt.labels, tt.ok)
+		}
+	}
+}
+
+func TestStatEntryValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		entry       APIStatEntry
+		average     bool
+		weights     APIStatEntry
+		labels      []string
+		values      map[string]float64
+		unparseable float64
+	}{
+		{
+			name:   "typical payload",
+			entry:  APIStatEntry{"GET /collections": 1.5, "POST /collections/products/documents/search": 2},
+			labels: []string{"GET /collections", "POST /collections/products/documents/search"},
+		},
+		{
+			name:   "keys without a method and with extra spaces",
+			entry:  APIStatEntry{"/health": 1, " GET  /debug ": 3},
+			labels: []string{" /health", "GET /debug"},
+		},
+		{
+			name:        "unparseable keys are skipped and counted",
+			entry:       APIStatEntry{"GET /collections": 1, "garbage": 2, "": 3, "get /debug": 4},
+			labels:      []string{"GET /collections"},
+			unparseable: 3,
+		},
+		{
+			name:   "colliding keys are summed",
+			entry:  APIStatEntry{"GET /a?x=1": 1, "GET /a?y=2": 2, "GET /b": 3, "GET  /b": 4},
+			labels: []string{"GET /a", "GET /b"},
+			values: map[string]float64{"GET /a": 3, "GET /b": 7},
+		},
+		{
+			name:    "colliding averages are weighted by requests",
+			entry:   APIStatEntry{"GET /a?x=1": 10, "GET /a?y=2": 40, "GET /b": 3, "GET  /b": 5},
+			average: true,
+			weights: APIStatEntry{"GET /a?x=1": 3, "GET /a?y=2": 1},
+			labels:  []string{"GET /a", "GET /b"},
+			values:  map[string]float64{"GET /a": 17.5, "GET /b": 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &upstream{
+				logger:  log.New(),
+				url:     &url.URL{Scheme: "http", Host: "localhost:8108"},
+				metrics: NewUpstreamMetrics(false),
+			}
+			values := statEntryValues(u, "latency_ms", tt.entry, tt.average, tt.weights, false)
+
+			var got []string
+			for _, v := range values {
+				if v.labels[0] != "http://localhost:8108" {
+					t.Errorf("unexpected cluster label %q", v.labels[0])
+				}
+				key := strings.Join(v.labels[1:], " ")
+				got = append(got, key)
+				if want, ok := tt.values[key]; ok && v.value != want {
+					t.Errorf("got value %v for %q, want %v", v.value, key, want)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.labels) {
+				t.Errorf("got labels %q, want %q", got, tt.labels)
+			}
+
+			var counted dto.Metric
+			if err := u.metrics.unparseableKeys.WithLabelValues("/stats.json", "http://localhost:8108", "latency_ms").Write(&counted); err != nil {
+				t.Fatal(err)
+			}
+			if counted := counted.GetCounter().GetValue(); counted != tt.unparseable {
+				t.Errorf("counted %v unparseable keys, want %v", counted, tt.unparseable)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// initialBackoff is how long scrapes of a collector are skipped after its first failed scrape,
+// doubling with each further one.
+const initialBackoff = time.Second
+
+// targetBackoff skips scrapes of the collectors of a node which keep failing, backing off each
+// collector exponentially up to max and resetting it after its first successful scrape. Keeping
+// the state per collector lets subsets of the collectors, e.g. scraped with collect[] or on their
+// own interval, back off without affecting each other.
+type targetBackoff struct {
+	max time.Duration
+
+	mtx        sync.Mutex
+	collectors map[string]*backoffState
+}
+
+type backoffState struct {
+	delay time.Duration
+	next  time.Time
+}
+
+func newTargetBackoff(max time.Duration) *targetBackoff {
+	if max <= 0 {
+		return nil
+	}
+	return &targetBackoff{max: max, collectors: make(map[string]*backoffState)}
+}
+
+// allow reports whether the named collector may be scraped now, and otherwise until when it is
+// skipped.
+func (b *targetBackoff) allow(name string) (bool, time.Time) {
+	if b == nil {
+		return true, time.Time{}
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	state, ok := b.collectors[name]
+	if !ok {
+		return true, time.Time{}
+	}
+	return !time.Now().Before(state.next), state.next
+}
+
+// record updates the backoff of the named collector after a scrape, returning the longest current
+// delay of the node's collectors, 0 if none is backing off.
+func (b *targetBackoff) record(name string, success bool) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	state, ok := b.collectors[name]
+	if !ok {
+		state = &backoffState{}
+		b.collectors[name] = state
+	}
+	if success {
+		state.delay = 0
+		state.next = time.Time{}
+	} else {
+		switch {
+		case state.delay == 0:
+			state.delay = initialBackoff
+		case state.delay < b.max:
+			state.delay *= 2
+		}
+		if state.delay > b.max {
+			state.delay = b.max
+		}
+		state.next = time.Now().Add(state.delay)
+	}
+
+	var longest time.Duration
+	for _, s := range b.collectors {
+		if s.delay > longest {
+			longest = s.delay
+		}
+	}
+	return longest
+}
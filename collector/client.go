@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"context"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Client fetches Typesense's endpoints through the same transport, retries, response size limit
+// and error handling as the collectors, returning the decoded responses instead of metrics.
+type Client struct {
+	upstream            *upstream
+	collectionsPageSize int
+}
+
+// NewClient creates a Client for the node at config.URL, using config.Client for requests.
+// Requests are recorded in config.UpstreamMetrics if set.
+func NewClient(config Config) *Client {
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+	if config.UpstreamMetrics == nil {
+		config.UpstreamMetrics = NewUpstreamMetrics(false)
+	}
+	config.throttle = &throttle{}
+	return &Client{
+		upstream:            newUpstream(config),
+		collectionsPageSize: config.CollectionsPageSize,
+	}
+}
+
+// FetchStats fetches /stats.json.
+func (c *Client) FetchStats(ctx context.Context) (*APIStatsResponse, error) {
+	var resp APIStatsResponse
+	if _, err := c.upstream.fetchJSON(ctx, "/stats.json", &resp, false); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FetchClusterMetrics fetches /metrics.json.
+func (c *Client) FetchClusterMetrics(ctx context.Context) (*ClusterMetricsResponse, error) {
+	var resp ClusterMetricsResponse
+	if _, err := c.upstream.fetchJSON(ctx, "/metrics.json", &resp, false); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FetchCollections lists all collections, paginating as configured by CollectionsPageSize.
+func (c *Client) FetchCollections(ctx context.Context) ([]CollectionResponse, error) {
+	return fetchCollectionPages(ctx, c.collectionsPageSize, func(ctx context.Context, query url.Values) ([]CollectionResponse, error) {
+		var resp []CollectionResponse
+		_, err := c.upstream.fetchJSONQuery(ctx, "/collections", query, &resp, false)
+		return resp, err
+	})
+}
@@ -1,12 +1,8 @@
 package collector
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"context"
 	"net/url"
-	"path"
 	"time"
 
 	prometheus "github.com/prometheus/client_golang/prometheus"
@@ -18,12 +14,24 @@ var (
 )
 
 type clusterMetric struct {
-	Type  prometheus.ValueType
-	Desc  *prometheus.Desc
-	Value func(resp clusterMetricsResponse) float64
+	Type prometheus.ValueType
+	Desc *prometheus.Desc
+	// Field is the metrics.json field the metric is read from.
+	Field string
+	Value func(resp ClusterMetricsResponse) float64
 }
 
-type clusterMetricsResponse struct {
+// clusterRatio is a ratio between two metrics.json fields, computed by the exporter.
+type clusterRatio struct {
+	Desc *prometheus.Desc
+	// Numerator and Denominator are the metrics.json fields the ratio is computed from.
+	Numerator   string
+	Denominator string
+	Value       func(resp ClusterMetricsResponse) (float64, float64)
+}
+
+// ClusterMetricsResponse is the decoded response of /metrics.json.
+type ClusterMetricsResponse struct {
 	SystemCPU1ActivePercentage        float64 `json:"system_cpu1_active_percentage,string"`
 	SystemCPU2ActivePercentage        float64 `json:"system_cpu2_active_percentage,string"`
 	SystemCPU3ActivePercentage        float64 `json:"system_cpu3_active_percentage,string"`
@@ -42,38 +50,58 @@ type clusterMetricsResponse struct {
 	TypesenseMemoryMetadataBytes      int     `json:"typesense_memory_metadata_bytes,string"`
 	TypesenseMemoryResidentBytes      int     `json:"typesense_memory_resident_bytes,string"`
 	TypesenseMemoryRetainedBytes      int     `json:"typesense_memory_retained_bytes,string"`
+
+	fields fieldSet
+}
+
+// UnmarshalJSON implements json.Unmarshaler, recording which fields the server reported. Releases
+// differ in encoding values as strings or numbers, which decodeFields accepts either way, and in
+// which fields they report, which are skipped if missing; none was renamed.
+func (r *ClusterMetricsResponse) UnmarshalJSON(b []byte) error {
+	type plain ClusterMetricsResponse
+	fields, err := decodeFields(b, (*plain)(r))
+	r.fields = fields
+	return err
 }
 
 type ClusterMetrics struct {
-	logger *log.Logger
-	client *http.Client
-	url    *url.URL
+	logger   *log.Logger
+	url      *url.URL
+	upstream *upstream
 
-	up                              prometheus.Gauge
-	totalScrapes, jsonParseFailures prometheus.Counter
+	*targetTracker
+
+	up prometheus.Gauge
 
 	metrics []*clusterMetric
+	ratios  []*clusterRatio
+	// compat holds the descriptions of the metrics' compatibility series, if enabled.
+	compat map[*clusterMetric]*prometheus.Desc
+}
+
+func init() {
+	Register("cluster_metrics", true, func(config Config) (Collector, error) {
+		return NewClusterMetrics(config), nil
+	})
 }
 
-func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *ClusterMetrics {
+// NewClusterMetrics creates a new ClusterMetrics
+func NewClusterMetrics(config Config) *ClusterMetrics {
 	subsystem := "cluster_metrics"
+	url := config.URL
+
+	upstream := newUpstream(config)
 
-	return &ClusterMetrics{
-		logger: logger,
-		client: client,
-		url:    url,
+	c := &ClusterMetrics{
+		logger:   config.Logger,
+		url:      url,
+		upstream: upstream,
+
+		targetTracker: newTargetTracker(subsystem, upstream.endpointURL("/metrics.json"), url),
 
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: prometheus.BuildFQName(namespace, subsystem, "up"),
-			Help: "Was the last scrape of the Typesense stats.json endpoint successful",
-		}),
-		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: prometheus.BuildFQName(namespace, subsystem, "total_scrapes"),
-			Help: "Current total Typesense cluster metrics scrapes",
-		}),
-		jsonParseFailures: prometheus.NewCounter(prometheus.CounterOpts{
-			Name: prometheus.BuildFQName(namespace, subsystem, "json_parse_failures"),
-			Help: "Number of errors while parsing JSON",
+			Help: "Was the last scrape of the Typesense metrics.json endpoint successful",
 		}),
 
 		metrics: []*clusterMetric{
@@ -81,10 +109,11 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_active_bytes"),
-					"Total active memory in use by Typesense",
+					"Active memory in use by Typesense in bytes, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_active_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryActiveBytes)
 				},
 			},
@@ -92,10 +121,11 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_allocated_bytes"),
-					"Total allocated memory in use by Typesense",
+					"Allocated memory in use by Typesense in bytes, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_allocated_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryAllocatedBytes)
 				},
 			},
@@ -103,10 +133,11 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_fragmentation_ratio"),
-					"Fragmentation ratio for Typesense memory",
+					"Fragmentation ratio of Typesense memory, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_fragmentation_ratio",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryFragmentationRatio)
 				},
 			},
@@ -114,10 +145,11 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_mapped_bytes"),
-					"Total mapped memory in use by Typesense",
+					"Mapped memory in use by Typesense in bytes, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_mapped_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryMappedBytes)
 				},
 			},
@@ -125,10 +157,11 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_metadata_bytes"),
-					"Total memory used for metadata by Typesense",
+					"Memory used for metadata by Typesense in bytes, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_metadata_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryMetadataBytes)
 				},
 			},
@@ -136,10 +169,11 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_resident_bytes"),
-					"Total resident memory in use by Typesense",
+					"Resident memory in use by Typesense in bytes, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_resident_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryResidentBytes)
 				},
 			},
@@ -147,15 +181,100 @@ func NewClusterMetrics(logger *log.Logger, client *http.Client, url *url.URL) *C
 				Type: prometheus.GaugeValue,
 				Desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, subsystem, "memory_retained_bytes"),
-					"Total retained memory in use by Typesense",
+					"Retained memory in use by Typesense in bytes, from /metrics.json",
 					defaultClusterMetricsLabels, nil,
 				),
-				Value: func(resp clusterMetricsResponse) float64 {
+				Field: "typesense_memory_retained_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
 					return float64(resp.TypesenseMemoryRetainedBytes)
 				},
 			},
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsystem, "system_disk_total_bytes"),
+					"Total size of the disk holding the Typesense data directory in bytes, from /metrics.json",
+					defaultClusterMetricsLabels, nil,
+				),
+				Field: "system_disk_total_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
+					return float64(resp.SystemDiskTotalBytes)
+				},
+			},
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsystem, "system_disk_used_bytes"),
+					"Used space on the disk holding the Typesense data directory in bytes, from /metrics.json",
+					defaultClusterMetricsLabels, nil,
+				),
+				Field: "system_disk_used_bytes",
+				Value: func(resp ClusterMetricsResponse) float64 {
+					return float64(resp.SystemDiskUsedBytes)
+				},
+			},
 		},
 	}
+
+	if config.DerivedRatios {
+		c.ratios = []*clusterRatio{
+			{
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsystem, "system_disk_usage_ratio"),
+					"Ratio of used to total space on the disk holding the Typesense data directory, computed from /metrics.json",
+					defaultClusterMetricsLabels, nil,
+				),
+				Numerator:   "system_disk_used_bytes",
+				Denominator: "system_disk_total_bytes",
+				Value: func(resp ClusterMetricsResponse) (float64, float64) {
+					return float64(resp.SystemDiskUsedBytes), float64(resp.SystemDiskTotalBytes)
+				},
+			},
+			{
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsystem, "system_memory_usage_ratio"),
+					"Ratio of used to total system memory, computed from /metrics.json",
+					defaultClusterMetricsLabels, nil,
+				),
+				Numerator:   "system_memory_used_bytes",
+				Denominator: "system_memory_total_bytes",
+				Value: func(resp ClusterMetricsResponse) (float64, float64) {
+					return float64(resp.SystemMemoryUsedBytes), float64(resp.SystemMemoryTotalBytes)
+				},
+			},
+			{
+				// Resident rather than allocated memory, as fragmented memory is unavailable to
+				// the rest of the system too.
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, subsystem, "memory_resident_ratio"),
+					"Ratio of memory resident in Typesense, including fragmentation, to total system memory, computed from /metrics.json",
+					defaultClusterMetricsLabels, nil,
+				),
+				Numerator:   "typesense_memory_resident_bytes",
+				Denominator: "system_memory_total_bytes",
+				Value: func(resp ClusterMetricsResponse) (float64, float64) {
+					return float64(resp.TypesenseMemoryResidentBytes), float64(resp.SystemMemoryTotalBytes)
+				},
+			},
+		}
+	}
+
+	if config.CompatNames {
+		c.compat = make(map[*clusterMetric]*prometheus.Desc, len(c.metrics))
+		for _, metric := range c.metrics {
+			c.compat[metric] = newCompatDesc(metric.Field, "/metrics.json", defaultClusterMetricsLabels)
+		}
+	}
+	return c
+}
+
+// MinServerVersion implements VersionedCollector; /metrics.json was added in Typesense 0.16.0.
+func (c *ClusterMetrics) MinServerVersion() string {
+	return "0.16.0"
+}
+
+func (c *ClusterMetrics) upGauge() prometheus.Gauge {
+	return c.up
 }
 
 // Describe set Prometheus metrics descriptions.
@@ -163,71 +282,73 @@ func (c *ClusterMetrics) Describe(ch chan<- *prometheus.Desc) {
 	for _, metric := range c.metrics {
 		ch <- metric.Desc
 	}
+	for _, ratio := range c.ratios {
+		ch <- ratio.Desc
+	}
+	for _, desc := range c.compat {
+		ch <- desc
+	}
 
 	ch <- c.up.Desc()
-	ch <- c.totalScrapes.Desc()
-	ch <- c.jsonParseFailures.Desc()
 }
 
-// Collect collects cluster metrics.
-func (c *ClusterMetrics) Collect(ch chan<- prometheus.Metric) {
-	var err error
-	c.totalScrapes.Inc()
+// Update implements the Collector interface.
+func (c *ClusterMetrics) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
 	defer func() {
 		ch <- c.up
-		ch <- c.totalScrapes
-		ch <- c.jsonParseFailures
 	}()
 
 	start := time.Now()
-	resp, err := c.fetchAndDecodeClusterMetrics()
+	resp, err := c.fetchAndDecodeClusterMetrics(ctx)
+	c.report(start, err)
 	if err != nil {
 		c.up.Set(0)
-		c.logger.WithError(err).Warnln("failed to fetch and decode cluster metrics")
-		return
+		return err
 	}
 	c.up.Set(1)
+	c.upstream.markSuccess("cluster_metrics")
 
 	c.logger.WithField("duration", time.Since(start)).Debugln("fetched cluster metrics successfully")
 
 	for _, metric := range c.metrics {
+		if !resp.fields.has(metric.Field) {
+			c.logger.WithField("field", metric.Field).Debugln("field not reported by Typesense, skipping")
+			continue
+		}
 		ch <- prometheus.MustNewConstMetric(
 			metric.Desc,
 			metric.Type,
 			metric.Value(resp),
 			c.url.String(),
 		)
+		if desc, ok := c.compat[metric]; ok {
+			if v, ok := rawFieldValue(resp, metric.Field); ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, c.url.String())
+			}
+		}
 	}
-}
 
-func (c *ClusterMetrics) fetchAndDecodeClusterMetrics() (clusterMetricsResponse, error) {
-	var resp clusterMetricsResponse
-
-	u := *c.url
-	u.Path = path.Join(u.Path, "/metrics.json")
-	res, err := c.client.Get(u.String())
-	if err != nil {
-		return resp, fmt.Errorf("failed to get cluster metrics from %s: %s", u.String(), err)
-	}
-	defer func() {
-		if err := res.Body.Close(); err != nil {
-			c.logger.WithError(err).Warnln("failed to close http.Client")
+	for _, ratio := range c.ratios {
+		if !resp.fields.has(ratio.Numerator) || !resp.fields.has(ratio.Denominator) {
+			continue
 		}
-	}()
-
-	if res.StatusCode != http.StatusOK {
-		return resp, fmt.Errorf("HTTP request failed with code %d", res.StatusCode)
+		numerator, denominator := ratio.Value(resp)
+		if denominator == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(ratio.Desc, prometheus.GaugeValue, numerator/denominator, c.url.String())
 	}
 
-	bts, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		c.jsonParseFailures.Inc()
-		return resp, err
-	}
-	if err := json.Unmarshal(bts, &resp); err != nil {
-		c.jsonParseFailures.Inc()
-		return resp, err
+	return nil
+}
+
+func (c *ClusterMetrics) fetchAndDecodeClusterMetrics(ctx context.Context) (ClusterMetricsResponse, error) {
+	var resp ClusterMetricsResponse
+
+	bts, err := c.upstream.fetchJSON(ctx, "/metrics.json", &resp, c.recordingPayloads())
+	if bts != nil {
+		c.recordPayload(bts)
 	}
 
-	return resp, nil
+	return resp, err
 }
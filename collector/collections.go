@@ -0,0 +1,319 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	defaultCollectionLabels = []string{"cluster", "collection"}
+)
+
+type collectionMetric struct {
+	Type  prometheus.ValueType
+	Desc  *prometheus.Desc
+	Value func(coll CollectionResponse) float64
+}
+
+// CollectionField is a field of a collection's schema.
+type CollectionField struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Facet bool   `json:"facet"`
+}
+
+// CollectionResponse is a collection as listed by /collections.
+type CollectionResponse struct {
+	Name                string            `json:"name"`
+	NumDocuments        float64           `json:"num_documents"`
+	CreatedAt           float64           `json:"created_at"`
+	NumMemoryShards     float64           `json:"num_memory_shards"`
+	DefaultSortingField string            `json:"default_sorting_field"`
+	EnableNestedFields  bool              `json:"enable_nested_fields"`
+	Fields              []CollectionField `json:"fields"`
+
+	// Schema is the raw field list, used to detect schema changes including properties not
+	// decoded into Fields.
+	Schema json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, keeping the raw field list alongside the decoded one.
+func (c *CollectionResponse) UnmarshalJSON(b []byte) error {
+	type plain CollectionResponse
+	var raw struct {
+		plain
+		Fields json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*c = CollectionResponse(raw.plain)
+	c.Schema = raw.Fields
+	if len(raw.Fields) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw.Fields, &c.Fields)
+}
+
+type Collections struct {
+	logger   *log.Logger
+	url      *url.URL
+	upstream *upstream
+
+	*targetTracker
+
+	up prometheus.Gauge
+
+	metrics []*collectionMetric
+	info    *prometheus.Desc
+
+	pageSize int
+
+	schemaChanges *prometheus.CounterVec
+	schemasMtx    sync.Mutex
+	schemas       map[string][sha256.Size]byte
+}
+
+func init() {
+	Register("collections", false, func(config Config) (Collector, error) {
+		return NewCollections(config), nil
+	})
+}
+
+// NewCollections creates a new Collections
+func NewCollections(config Config) *Collections {
+	subsystem := "collections"
+	url := config.URL
+
+	upstream := newUpstream(config)
+
+	return &Collections{
+		logger:   config.Logger,
+		url:      url,
+		upstream: upstream,
+		pageSize: config.CollectionsPageSize,
+
+		targetTracker: newTargetTracker(subsystem, upstream.endpointURL("/collections"), url),
+
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "up"),
+			Help: "Was the last scrape of the Typesense collections endpoint successful",
+		}),
+
+		metrics: []*collectionMetric{
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, "collection", "documents"),
+					"Number of documents in the collection, from /collections",
+					defaultCollectionLabels, nil,
+				),
+				Value: func(coll CollectionResponse) float64 {
+					return coll.NumDocuments
+				},
+			},
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, "collection", "fields"),
+					"Number of fields in the collection schema, from /collections",
+					defaultCollectionLabels, nil,
+				),
+				Value: func(coll CollectionResponse) float64 {
+					return float64(len(coll.Fields))
+				},
+			},
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, "collection", "facet_fields"),
+					"Number of facetable fields in the collection schema, from /collections",
+					defaultCollectionLabels, nil,
+				),
+				Value: func(coll CollectionResponse) float64 {
+					var n int
+					for _, f := range coll.Fields {
+						if f.Facet {
+							n++
+						}
+					}
+					return float64(n)
+				},
+			},
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, "collection", "created_timestamp_seconds"),
+					"Unix timestamp at which the collection was created, from /collections",
+					defaultCollectionLabels, nil,
+				),
+				Value: func(coll CollectionResponse) float64 {
+					return coll.CreatedAt
+				},
+			},
+			{
+				Type: prometheus.GaugeValue,
+				Desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, "collection", "memory_shards"),
+					"Number of in-memory shards of the collection, from /collections",
+					defaultCollectionLabels, nil,
+				),
+				Value: func(coll CollectionResponse) float64 {
+					return coll.NumMemoryShards
+				},
+			},
+		},
+
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "collection", "info"),
+			"A metric with a constant '1' value labeled by the properties of the collection, from /collections",
+			[]string{"cluster", "collection", "default_sorting_field", "enable_nested_fields"}, nil,
+		),
+
+		schemaChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, "collection", "schema_changes_total"),
+			Help: "Number of times the schema of the collection changed between scrapes",
+		}, defaultCollectionLabels),
+		schemas: make(map[string][sha256.Size]byte),
+	}
+}
+
+func (c *Collections) upGauge() prometheus.Gauge {
+	return c.up
+}
+
+// Describe set Prometheus metrics descriptions.
+func (c *Collections) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c.metrics {
+		ch <- metric.Desc
+	}
+	ch <- c.info
+
+	c.schemaChanges.Describe(ch)
+
+	ch <- c.up.Desc()
+}
+
+// Update implements the Collector interface.
+func (c *Collections) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	defer func() {
+		ch <- c.up
+	}()
+
+	start := time.Now()
+	resp, err := c.fetchAndDecodeCollections(ctx)
+	c.report(start, err)
+	if err != nil {
+		c.up.Set(0)
+		return err
+	}
+	c.up.Set(1)
+	c.upstream.markSuccess("collections")
+
+	c.logger.WithField("duration", time.Since(start)).Debugln("fetched collections successfully")
+
+	c.trackSchemas(resp)
+	c.schemaChanges.Collect(ch)
+
+	for _, coll := range resp {
+		for _, metric := range c.metrics {
+			ch <- prometheus.MustNewConstMetric(
+				metric.Desc,
+				metric.Type,
+				metric.Value(coll),
+				c.url.String(), coll.Name,
+			)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.info,
+			prometheus.GaugeValue,
+			1,
+			c.url.String(), coll.Name, coll.DefaultSortingField, strconv.FormatBool(coll.EnableNestedFields),
+		)
+	}
+
+	return nil
+}
+
+func (c *Collections) fetchAndDecodeCollections(ctx context.Context) ([]CollectionResponse, error) {
+	return fetchCollectionPages(ctx, c.pageSize, c.fetchAndDecodeCollectionsPage)
+}
+
+// fetchCollectionPages lists all collections with fetchPage, pageSize at a time, or in a single
+// request if pageSize is 0.
+func fetchCollectionPages(ctx context.Context, pageSize int, fetchPage func(context.Context, url.Values) ([]CollectionResponse, error)) ([]CollectionResponse, error) {
+	if pageSize <= 0 {
+		return fetchPage(ctx, nil)
+	}
+
+	var colls []CollectionResponse
+	seen := make(map[string]bool)
+	for offset := 0; ; offset += pageSize {
+		page, err := fetchPage(ctx, url.Values{
+			"limit":  []string{strconv.Itoa(pageSize)},
+			"offset": []string{strconv.Itoa(offset)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, coll := range page {
+			if !seen[coll.Name] {
+				seen[coll.Name] = true
+				colls = append(colls, coll)
+				added++
+			}
+		}
+		// Servers without pagination return every collection regardless of limit and offset, which
+		// shows up as a page larger than requested or one repeating collections already seen.
+		if len(page) != pageSize || added == 0 {
+			return colls, nil
+		}
+	}
+}
+
+func (c *Collections) fetchAndDecodeCollectionsPage(ctx context.Context, query url.Values) ([]CollectionResponse, error) {
+	var resp []CollectionResponse
+
+	bts, err := c.upstream.fetchJSONQuery(ctx, "/collections", query, &resp, c.recordingPayloads())
+	if bts != nil {
+		c.recordPayload(bts)
+	}
+
+	return resp, err
+}
+
+// trackSchemas counts collections whose schema hash differs from the previous scrape. Collections
+// which disappeared are forgotten, so a re-created collection starts from its new schema.
+func (c *Collections) trackSchemas(colls []CollectionResponse) {
+	c.schemasMtx.Lock()
+	defer c.schemasMtx.Unlock()
+
+	seen := make(map[string]bool, len(colls))
+	for _, coll := range colls {
+		seen[coll.Name] = true
+		sum := sha256.Sum256(coll.Schema)
+		counter := c.schemaChanges.WithLabelValues(c.url.String(), coll.Name)
+		if prev, ok := c.schemas[coll.Name]; ok && prev != sum {
+			c.logger.WithField("collection", coll.Name).Infoln("collection schema changed")
+			counter.Inc()
+		}
+		c.schemas[coll.Name] = sum
+	}
+
+	for name := range c.schemas {
+		if !seen[name] {
+			delete(c.schemas, name)
+			c.schemaChanges.DeleteLabelValues(c.url.String(), name)
+		}
+	}
+}
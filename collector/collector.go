@@ -2,8 +2,12 @@ package collector
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,12 +20,33 @@ const namespace = "typesense"
 
 var (
 	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_duration_seconds"),
+		"Duration of a collector scrape in seconds",
+		[]string{"collector", "target"},
+		nil,
+	)
+	scrapeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_success"),
+		"Whether a collector succeeded",
+		[]string{"collector", "target"},
+		nil,
+	)
+	seriesEmittedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "series_emitted"),
+		"Number of series emitted by a collector in this scrape, excluding the exporter's series about the scrape",
+		[]string{"collector", "target"},
+		nil,
+	)
+
+	// legacyScrapeDurationDesc and legacyScrapeSuccessDesc are the names scrapeDurationDesc and
+	// scrapeSuccessDesc had before exporter telemetry moved under typesense_exporter_.
+	legacyScrapeDurationDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "scrape", "duration_seconds"),
-		"typesense_exporter: Duration of a collector scrape.",
+		"typesense_exporter: Duration of a collector scrape in seconds.",
 		[]string{"collector"},
 		nil,
 	)
-	scrapeSuccessDesc = prometheus.NewDesc(
+	legacyScrapeSuccessDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "scrape", "success"),
 		"typesense_exporter: Whether a collector succeeded.",
 		[]string{"collector"},
@@ -29,67 +54,469 @@ var (
 	)
 )
 
+var (
+	factoriesMtx   sync.RWMutex
+	factories      = make(map[string]Factory)
+	defaultEnabled = make(map[string]bool)
+)
+
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Get new metrics and expose them via prometheus registry.
 	Update(context.Context, chan<- prometheus.Metric) error
 }
 
+// Config holds everything a collector needs to scrape a Typesense node.
+type Config struct {
+	Logger          *log.Logger
+	Client          *http.Client
+	URL             *url.URL
+	UpstreamMetrics *UpstreamMetrics
+	LegacyNames     bool
+	// MaxResponseSize limits the size of response bodies read from Typesense, 0 for no limit.
+	MaxResponseSize int64
+	// Retries is how often requests failing with a transport error or a 502 or 504 response are
+	// retried within the scrape.
+	Retries int
+	// StrictDecoding fails scrapes whose responses contain fields the exporter doesn't know, counting
+	// them, to notice data added by Typesense upgrades.
+	StrictDecoding bool
+	// APIStatsSampleInterval is how often the api_stats collector samples /stats.json between
+	// scrapes to expose the min, max and avg since the last scrape, 0 to disable.
+	APIStatsSampleInterval time.Duration
+	// DerivedRatios makes the cluster_metrics collector expose usage ratios computed from the
+	// metrics it reads, e.g. of used to total disk space.
+	DerivedRatios bool
+	// CompatNames makes the api_stats and cluster_metrics collectors additionally expose the raw
+	// fields of Typesense's responses under the typesense_<field> names of the community
+	// typesense-prometheus-exporter.
+	CompatNames bool
+	// LatencyUnit is the unit the api_stats collector exposes latencies in, LatencyUnitSeconds if
+	// empty.
+	LatencyUnit string
+	// APIStatsCollectionLabel moves the collection name out of /collections/<name>/... endpoints
+	// of the api_stats collector into a collection label.
+	APIStatsCollectionLabel bool
+	// CollectionsPageSize is the number of collections listed per request, 0 to list all at once.
+	CollectionsPageSize int
+	// Workers is the number of collectors scraping the node at once, 0 for no limit.
+	Workers int
+	// ScrapeLimiter, if set, is shared by the collectors of several nodes to cap how many of them
+	// run at once overall.
+	ScrapeLimiter *ScrapeLimiter
+	// ScrapeTimeout bounds a scrape of the node, including time spent waiting for workers, 0 for no
+	// limit besides the deadline of the scrape itself.
+	ScrapeTimeout time.Duration
+	// FailureBackoffMax, if set, skips scrapes of a collector after its scrapes failed, for 1s
+	// doubling up to this duration, until one succeeds again.
+	FailureBackoffMax time.Duration
+	// Active, if set, reports whether the exporter currently scrapes Typesense, e.g. while holding
+	// the leader lease. Background work such as API stats sampling pauses while it returns false.
+	Active func() bool
+
+	// throttle is shared by the collectors of a node to honor its Retry-After.
+	throttle *throttle
+}
+
+// Factory creates a collector from the shared configuration.
+type Factory func(Config) (Collector, error)
+
+// Register makes a collector available under name, so it can be enabled alongside the built-in
+// collectors. It is meant to be called from init functions and panics on duplicate names.
+func Register(name string, isDefaultEnabled bool, factory Factory) {
+	factoriesMtx.Lock()
+	defer factoriesMtx.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("collector %q registered twice", name))
+	}
+	factories[name] = factory
+	defaultEnabled[name] = isDefaultEnabled
+}
+
+// Collectors returns the names of all registered collectors, mapped to whether they are enabled by
+// default.
+func Collectors() map[string]bool {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+
+	collectors := make(map[string]bool, len(defaultEnabled))
+	for name, enabled := range defaultEnabled {
+		collectors[name] = enabled
+	}
+	return collectors
+}
+
+// DefaultCollectors returns the sorted names of the collectors enabled by default.
+func DefaultCollectors() []string {
+	var names []string
+	for name, enabled := range Collectors() {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 type TypesenseCollector struct {
 	Collectors map[string]Collector
 	logger     *log.Logger
+	upstream   *upstream
+	client     *Client
+	deadline   time.Time
+
+	// workers limits the collectors of this node running at once, limiter those of all nodes.
+	workers       *ScrapeLimiter
+	limiter       *ScrapeLimiter
+	scrapeTimeout time.Duration
+	backoff       *targetBackoff
+
+	legacyNames bool
+	// legacyScrapes holds the per-collector typesense_<collector>_total_scrapes counters, which
+	// preceded typesense_exporter_scrapes_total, when legacy names are enabled.
+	legacyScrapes map[string]prometheus.Counter
+	// legacyParseFailures holds the typesense_<collector>_json_parse_failures counters of the
+	// collectors which had them, preceding the parse errors of
+	// typesense_exporter_upstream_errors_total, when legacy names are enabled.
+	legacyParseFailures map[string]prometheus.Counter
 }
 
-// NewTypesenseCollector creates a new TypesenseCollector
-func NewTypesenseCollector(logger *log.Logger, httpClient *http.Client, typesenseURL *url.URL) (*TypesenseCollector, error) {
+// NewTypesenseCollector creates a new TypesenseCollector running the named collectors.
+func NewTypesenseCollector(config Config, names ...string) (*TypesenseCollector, error) {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+
+	config.throttle = &throttle{}
 	collectors := make(map[string]Collector)
+	for _, name := range names {
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		c, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create collector %q: %s", name, err)
+		}
+		collectors[name] = c
+	}
+
+	var legacyScrapes, legacyParseFailures map[string]prometheus.Counter
+	if config.LegacyNames {
+		legacyScrapes = make(map[string]prometheus.Counter, len(names))
+		legacyParseFailures = make(map[string]prometheus.Counter)
+		for _, name := range names {
+			legacyScrapes[name] = prometheus.NewCounter(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, name, "total_scrapes"),
+				Help: "Current total Typesense " + strings.Replace(name, "_", " ", -1) + " scrapes",
+			})
+			if name == "api_stats" || name == "cluster_metrics" {
+				legacyParseFailures[name] = prometheus.NewCounter(prometheus.CounterOpts{
+					Name: prometheus.BuildFQName(namespace, name, "json_parse_failures"),
+					Help: "Number of errors while parsing JSON",
+				})
+			}
+		}
+	}
+
+	upstream := newUpstream(config)
+	return &TypesenseCollector{
+		Collectors:    collectors,
+		logger:        config.Logger,
+		upstream:      upstream,
+		client:        &Client{upstream: upstream, collectionsPageSize: config.CollectionsPageSize},
+		workers:       NewScrapeLimiter(config.Workers),
+		limiter:       config.ScrapeLimiter,
+		scrapeTimeout: config.ScrapeTimeout,
+		backoff:       newTargetBackoff(config.FailureBackoffMax),
+		legacyNames:   config.LegacyNames,
+		legacyScrapes: legacyScrapes,
+
+		legacyParseFailures: legacyParseFailures,
+	}, nil
+}
+
+// Filter returns a TypesenseCollector running only the named collectors, sharing their state with e.
+func (e TypesenseCollector) Filter(names ...string) (*TypesenseCollector, error) {
+	collectors := make(map[string]Collector, len(names))
+	for _, name := range names {
+		c, ok := e.Collectors[name]
+		if !ok {
+			return nil, fmt.Errorf("collector %q not enabled", name)
+		}
+		collectors[name] = c
+	}
 
 	return &TypesenseCollector{
-		Collectors: collectors,
-		logger:     logger,
+		Collectors:    collectors,
+		logger:        e.logger,
+		upstream:      e.upstream,
+		client:        e.client,
+		workers:       e.workers,
+		limiter:       e.limiter,
+		scrapeTimeout: e.scrapeTimeout,
+		backoff:       e.backoff,
+		legacyNames:   e.legacyNames,
+		legacyScrapes: e.legacyScrapes,
+
+		legacyParseFailures: e.legacyParseFailures,
 	}, nil
 }
 
+// WithDeadline returns a copy of e which stops waiting for collectors at deadline, e.g. to serve a
+// partial response before a scrape times out. The scrape timeout of the node still applies if it
+// is earlier.
+func (e TypesenseCollector) WithDeadline(deadline time.Time) *TypesenseCollector {
+	e.deadline = deadline
+	return &e
+}
+
+// Client returns a Client for the node, sharing the collectors' transport, telemetry and
+// Retry-After state.
+func (e TypesenseCollector) Client() *Client {
+	return e.client
+}
+
+// Close stops the background work of the collectors, e.g. sampling API stats, once the node isn't
+// scraped anymore.
+func (e TypesenseCollector) Close() {
+	for _, c := range e.Collectors {
+		if closer, ok := c.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
 // Describe implements the prometheus.Collector interface.
 func (e TypesenseCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- seriesEmittedDesc
+	if e.legacyNames {
+		ch <- legacyScrapeDurationDesc
+		ch <- legacyScrapeSuccessDesc
+	}
+
+	for name, c := range e.Collectors {
+		if counter, ok := e.legacyScrapes[name]; ok {
+			ch <- counter.Desc()
+		}
+		if counter, ok := e.legacyParseFailures[name]; ok {
+			ch <- counter.Desc()
+		}
+		if d, ok := c.(interface {
+			Describe(chan<- *prometheus.Desc)
+		}); ok {
+			d.Describe(ch)
+		}
+	}
 }
 
-// Collect implements the prometheus.Collector interface.
+// Collect implements the prometheus.Collector interface. Collectors run concurrently, as far as the
+// workers and scrape limiter allow. With a deadline, collectors still running or waiting when it
+// passes are reported as failed and their metrics dropped, so the metrics gathered so far can be
+// served before the scrape times out.
 func (e TypesenseCollector) Collect(ch chan<- prometheus.Metric) {
-	wg := sync.WaitGroup{}
-	ctx := context.TODO()
-	wg.Add(len(e.Collectors))
+	ctx := context.Background()
+	deadline := e.deadline
+	if e.scrapeTimeout > 0 {
+		if timeout := time.Now().Add(e.scrapeTimeout); deadline.IsZero() || timeout.Before(deadline) {
+			deadline = timeout
+		}
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	allowed := make(map[string]Collector, len(e.Collectors))
 	for name, c := range e.Collectors {
+		if ok, until := e.backoff.allow(name); !ok {
+			e.logger.WithFields(log.Fields{"name": name, "until": until}).Debugln("backing off from failing collector, skipping scrape")
+			for _, m := range e.scrapeResult(name, 0, 0) {
+				ch <- m
+			}
+			continue
+		}
+		allowed[name] = c
+	}
+	if len(allowed) < len(e.Collectors) {
+		e.upstream.countSkippedScrape()
+	}
+	if len(allowed) == 0 {
+		return
+	}
+
+	collectors := e.activeCollectors(ctx, allowed)
+
+	begin := time.Now()
+	results := make(chan collectorResult, len(collectors))
+	pending := make(map[string]bool, len(collectors))
+	for name, c := range collectors {
+		pending[name] = true
 		go func(name string, c Collector) {
-			execute(ctx, name, c, ch, e.logger)
-			wg.Done()
+			// Collectors still waiting for a worker at the deadline are reported as failed below.
+			if e.workers.acquire(ctx) != nil {
+				return
+			}
+			defer e.workers.release()
+			if e.limiter.acquire(ctx) != nil {
+				return
+			}
+			defer e.limiter.release()
+
+			results <- e.execute(ctx, name, c)
 		}(name, c)
 	}
-	wg.Wait()
+
+	for len(pending) > 0 {
+		select {
+		case res := <-results:
+			delete(pending, res.name)
+			e.upstream.setBackoff(e.backoff.record(res.name, res.success))
+			for _, m := range res.metrics {
+				ch <- m
+			}
+		case <-ctx.Done():
+			duration := time.Since(begin)
+			for name := range pending {
+				e.logger.WithFields(log.Fields{
+					"name":             name,
+					"duration_seconds": duration.Seconds(),
+				}).Errorln("collector did not finish before the scrape deadline")
+				e.upstream.setBackoff(e.backoff.record(name, false))
+				for _, m := range e.scrapeResult(name, duration, 0) {
+					ch <- m
+				}
+			}
+			return
+		}
+	}
+}
+
+// activeCollectors returns the collectors of candidates supported by the Typesense server. The
+// server version is only detected when a collector implements VersionedCollector; if that fails,
+// all collectors run.
+func (e TypesenseCollector) activeCollectors(ctx context.Context, candidates map[string]Collector) map[string]Collector {
+	versioned := false
+	for _, c := range candidates {
+		if _, ok := c.(VersionedCollector); ok {
+			versioned = true
+			break
+		}
+	}
+	if !versioned {
+		return candidates
+	}
+
+	version, err := e.upstream.serverVersion(ctx)
+	if err != nil {
+		e.logger.WithError(err).Warnln("failed to detect Typesense server version, running all collectors")
+		return candidates
+	}
+
+	collectors := make(map[string]Collector, len(candidates))
+	for name, c := range candidates {
+		if v, ok := c.(VersionedCollector); ok && !versionAtLeast(version, v.MinServerVersion()) {
+			e.logger.WithFields(log.Fields{
+				"name":        name,
+				"version":     version,
+				"min_version": v.MinServerVersion(),
+			}).Debugln("collector not supported by Typesense server, skipping")
+			continue
+		}
+		collectors[name] = c
+	}
+	return collectors
 }
 
-func execute(ctx context.Context, name string, c Collector, ch chan<- prometheus.Metric, logger *log.Logger) {
+// collectorResult holds the metrics of a finished collector.
+type collectorResult struct {
+	name    string
+	metrics []prometheus.Metric
+	success bool
+}
+
+func (e TypesenseCollector) execute(ctx context.Context, name string, c Collector) collectorResult {
+	ch := make(chan prometheus.Metric)
+	buffered := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		buffered <- metrics
+	}()
+
+	e.upstream.countScrape(name)
+
 	begin := time.Now()
 	err := c.Update(ctx, ch)
 	duration := time.Since(begin)
+	close(ch)
+	metrics := <-buffered
 	var success float64
 
 	if err != nil {
 		success = 0
-		logger.WithError(err).WithFields(log.Fields{
+		e.logger.WithError(err).WithFields(log.Fields{
 			"name":             name,
 			"duration_seconds": duration.Seconds(),
 		}).Errorln("collector failed")
 	} else {
 		success = 1
-		logger.WithFields(log.Fields{
+		e.logger.WithFields(log.Fields{
 			"name":             name,
 			"duration_seconds": duration.Seconds(),
 		}).Debugln("collector succeeded")
 	}
 
-	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
-	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	metrics = append(metrics, prometheus.MustNewConstMetric(
+		seriesEmittedDesc, prometheus.GaugeValue, float64(len(metrics)), name, e.upstream.url.String(),
+	))
+	if counter, ok := e.legacyScrapes[name]; ok {
+		counter.Inc()
+		metrics = append(metrics, counter)
+	}
+	if counter, ok := e.legacyParseFailures[name]; ok {
+		if errors.Is(err, ErrDecode) {
+			counter.Inc()
+		}
+		metrics = append(metrics, counter)
+	}
+	metrics = append(metrics, e.scrapeResult(name, duration, success)...)
+
+	return collectorResult{name: name, metrics: metrics, success: success == 1}
+}
+
+// upReporter is implemented by collectors with an up gauge.
+type upReporter interface {
+	upGauge() prometheus.Gauge
+}
+
+// StaleResult returns the metrics reporting the named collector as down, for serving in place of
+// cached results which became too old: its up gauge and typesense_exporter_scrape_success at 0.
+func (e TypesenseCollector) StaleResult(name string) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	if c, ok := e.Collectors[name].(upReporter); ok {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.upGauge().Desc(), prometheus.GaugeValue, 0))
+	}
+	return append(metrics, e.scrapeResult(name, 0, 0)...)
+}
+
+// scrapeResult returns the duration and success of the named collector's scrape, under the legacy
+// names too when enabled.
+func (e TypesenseCollector) scrapeResult(name string, duration time.Duration, success float64) []prometheus.Metric {
+	metrics := []prometheus.Metric{
+		prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name, e.upstream.url.String()),
+		prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name, e.upstream.url.String()),
+	}
+	if e.legacyNames {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(legacyScrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name),
+			prometheus.MustNewConstMetric(legacyScrapeSuccessDesc, prometheus.GaugeValue, success, name),
+		)
+	}
+	return metrics
 }
@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Units the latencies of /stats.json are exposed in.
+const (
+	// LatencyUnitSeconds exposes latencies converted to seconds, as *_latency_seconds.
+	LatencyUnitSeconds = "seconds"
+	// LatencyUnitMilliseconds exposes latencies in milliseconds as reported by Typesense, as
+	// *_latency_ms, instead of in seconds.
+	LatencyUnitMilliseconds = "milliseconds"
+	// LatencyUnitBoth exposes latencies both in seconds and in milliseconds.
+	LatencyUnitBoth = "both"
+)
+
+// isLatencyField reports whether the /stats.json field holds a latency in milliseconds.
+func isLatencyField(field string) bool {
+	return strings.HasSuffix(field, "latency_ms")
+}
+
+// newMillisDesc describes the series of a latency field of /stats.json in milliseconds, named
+// after the field within subsystem.
+func newMillisDesc(subsystem, field string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, field),
+		"Value of "+field+" in milliseconds, from /stats.json",
+		labels, nil,
+	)
+}
+
+// compatName returns the name the community typesense-prometheus-exporter exposes a Typesense
+// response field under: the field name prefixed with typesense_, unless it already is.
+func compatName(field string) string {
+	if strings.HasPrefix(field, namespace+"_") {
+		return field
+	}
+	return namespace + "_" + field
+}
+
+// newCompatDesc describes the compatibility series of a response field, carrying the raw value as
+// reported by Typesense.
+func newCompatDesc(field, endpoint string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		compatName(field),
+		"Value of "+field+" from "+endpoint+", for compatibility with typesense-prometheus-exporter",
+		labels, nil,
+	)
+}
+
+// rawFieldValue returns the value of the numeric field of the response struct resp decoded from
+// the JSON field, as reported by Typesense.
+func rawFieldValue(resp interface{}, field string) (float64, bool) {
+	v := reflect.Indirect(reflect.ValueOf(resp))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != field {
+			continue
+		}
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return f.Float(), true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(f.Int()), true
+		}
+		return 0, false
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Errors returned by the collectors, wrapped with details, for programs embedding them to tell
+// failures apart with errors.Is.
+var (
+	// ErrUnauthorized means Typesense rejected the API key with 401 or 403.
+	ErrUnauthorized = errors.New("Typesense rejected the credentials")
+	// ErrTimeout means a request to Typesense timed out or ran out of scrape time.
+	ErrTimeout = errors.New("request to Typesense timed out")
+	// ErrDecode means a response from Typesense couldn't be decoded.
+	ErrDecode = errors.New("failed to decode Typesense response")
+	// ErrNotReady means the Typesense node answered 503 because it isn't ready or lags behind.
+	ErrNotReady = errors.New("Typesense node not ready")
+)
+
+// StatusError is returned for responses from Typesense with a status other than 200. It matches
+// ErrUnauthorized for 401 and 403.
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	if e.unauthorized() {
+		return fmt.Sprintf("Typesense rejected the credentials with code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("HTTP request failed with code %d", e.StatusCode)
+}
+
+// Is implements errors.Is.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnauthorized && e.unauthorized()
+}
+
+func (e *StatusError) unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// NotReadyError is returned for 503 responses of a Typesense node which isn't ready, with the
+// reason as in typesense_node_not_ready and Typesense's message. It matches ErrNotReady.
+type NotReadyError struct {
+	Endpoint string
+	Reason   string
+	Message  string
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("Typesense node not ready (%s): %s", e.Reason, e.Message)
+}
+
+// Is implements errors.Is.
+func (e *NotReadyError) Is(target error) bool {
+	return target == ErrNotReady
+}
+
+// kindError makes err match the sentinel kind, keeping its message and the errors it wraps.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Is implements errors.Is.
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classify wraps err from a failed request so it matches ErrTimeout if it timed out.
+func classify(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &kindError{kind: ErrTimeout, err: err}
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fieldSet records which top-level fields a Typesense response contained.
+type fieldSet map[string]bool
+
+// has reports whether field was present in the response. A nil fieldSet, as left by responses that
+// were not decoded from JSON, contains every field.
+func (f fieldSet) has(field string) bool {
+	return f == nil || f[field]
+}
+
+// decodeFields decodes the JSON object b into the struct v in a single pass. Fields tagged with the
+// string option also accept bare numbers, as releases differ in how they encode them. It returns
+// the fields present in b, so metrics for fields the server doesn't report can be skipped instead
+// of exported as 0.
+func decodeFields(b []byte, v interface{}) (fieldSet, error) {
+	rv := reflect.ValueOf(v).Elem()
+	index := jsonFieldIndex(rv.Type())
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected JSON object, got %v", tok)
+	}
+
+	fields := make(fieldSet)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name := tok.(string)
+		fields[name] = true
+
+		f, ok := index[name]
+		if !ok {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		dst := rv.Field(f.index).Addr().Interface()
+		if !f.quoted {
+			if err := dec.Decode(dst); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if len(raw) > 1 && raw[0] == '"' {
+			raw = raw[1 : len(raw)-1]
+		}
+		if err := json.Unmarshal(raw, dst); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// jsonField is a struct field decoded from a JSON field.
+type jsonField struct {
+	index int
+	// quoted is set for fields tagged with the string option.
+	quoted bool
+}
+
+// jsonFieldIndexes caches the fields of the struct types decoded by decodeFields.
+var jsonFieldIndexes sync.Map
+
+// jsonFieldIndex maps the JSON field names of the struct type t to its fields.
+func jsonFieldIndex(t reflect.Type) map[string]jsonField {
+	if index, ok := jsonFieldIndexes.Load(t); ok {
+		return index.(map[string]jsonField)
+	}
+	index := make(map[string]jsonField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+		f := jsonField{index: i}
+		for _, opt := range tag[1:] {
+			f.quoted = f.quoted || opt == "string"
+		}
+		index[tag[0]] = f
+	}
+	jsonFieldIndexes.Store(t, index)
+	return index
+}
+
+// unknownFields returns the top-level fields of the JSON object, or of each object in the JSON
+// array, b that have no corresponding field in the struct v decodes into. Decoders implementing
+// json.Unmarshaler don't honor DisallowUnknownFields, so the fields are compared against the json
+// tags of v's struct type instead.
+func unknownFields(b []byte, v interface{}) ([]string, error) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var objects []map[string]json.RawMessage
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+		if err := json.Unmarshal(b, &objects); err != nil {
+			return nil, err
+		}
+	} else {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(b, &object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	known := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var unknown []string
+	for _, object := range objects {
+		for name := range object {
+			if !known[name] && !seen[name] {
+				seen[name] = true
+				unknown = append(unknown, name)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
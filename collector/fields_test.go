@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeFields(t *testing.T) {
+	type response struct {
+		Used    int     `json:"used_bytes,string"`
+		Ratio   float64 `json:"ratio,string"`
+		Latency float64 `json:"latency_ms"`
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		want   response
+		fields []string
+	}{
+		{
+			name:   "quoted numbers",
+			body:   `{"used_bytes": "1024", "ratio": "0.5", "latency_ms": 2.5}`,
+			want:   response{Used: 1024, Ratio: 0.5, Latency: 2.5},
+			fields: []string{"latency_ms", "ratio", "used_bytes"},
+		},
+		{
+			name:   "bare numbers in quoted fields",
+			body:   `{"used_bytes": 1024, "ratio": 0.5}`,
+			want:   response{Used: 1024, Ratio: 0.5},
+			fields: []string{"ratio", "used_bytes"},
+		},
+		{
+			name:   "unknown and nested fields are skipped",
+			body:   `{"new_field": {"a": [1, 2]}, "latency_ms": 1}`,
+			want:   response{Latency: 1},
+			fields: []string{"latency_ms", "new_field"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got response
+			fields, err := decodeFields([]byte(tt.body), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+			want := make(fieldSet)
+			for _, f := range tt.fields {
+				want[f] = true
+			}
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("got fields %v, want %v", fields, want)
+			}
+		})
+	}
+}
+
+func TestDecodeFieldsInvalid(t *testing.T) {
+	var v struct {
+		Used int `json:"used_bytes,string"`
+	}
+	for _, body := range []string{`[]`, `{"used_bytes": "abc"}`, `{"used_bytes": "1"`, `not json`} {
+		if _, err := decodeFields([]byte(body), &v); err == nil {
+			t.Errorf("decodeFields(%s) succeeded, want an error", body)
+		}
+	}
+}
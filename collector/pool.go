@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"context"
+)
+
+// ScrapeLimiter caps how many collectors run at once. Each target has one limiting its own
+// collectors, and a limiter can be shared between targets as a global ceiling.
+type ScrapeLimiter struct {
+	slots chan struct{}
+}
+
+// NewScrapeLimiter creates a ScrapeLimiter running at most n collectors at once, or nil, which
+// doesn't limit, for n <= 0.
+func NewScrapeLimiter(n int) *ScrapeLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &ScrapeLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot until ctx is done.
+func (l *ScrapeLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *ScrapeLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// SelfTestResult is the outcome of running a collector once for a self-test.
+type SelfTestResult struct {
+	Target    string `json:"target"`
+	Collector string `json:"collector"`
+	Success   bool   `json:"success"`
+	// Skipped is set for collectors the Typesense server is too old for.
+	Skipped         bool    `json:"skipped,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Series          int     `json:"series"`
+}
+
+// SelfTest runs each collector once, one after another, and reports whether it succeeded. The
+// metrics are discarded; the collectors' target state is updated as for any scrape.
+func (e TypesenseCollector) SelfTest(ctx context.Context) []SelfTestResult {
+	if e.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.scrapeTimeout)
+		defer cancel()
+	}
+
+	active := e.activeCollectors(ctx, e.Collectors)
+	names := make([]string, 0, len(e.Collectors))
+	for name := range e.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	target := e.upstream.url.String()
+	results := make([]SelfTestResult, 0, len(names))
+	for _, name := range names {
+		res := SelfTestResult{Target: target, Collector: name}
+		c, ok := active[name]
+		if !ok {
+			res.Success = true
+			res.Skipped = true
+			results = append(results, res)
+			continue
+		}
+
+		if err := e.limiter.acquire(ctx); err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		ch := make(chan prometheus.Metric)
+		series := make(chan int)
+		go func() {
+			n := 0
+			for range ch {
+				n++
+			}
+			series <- n
+		}()
+		begin := time.Now()
+		err := c.Update(ctx, ch)
+		res.DurationSeconds = time.Since(begin).Seconds()
+		close(ch)
+		res.Series = <-series
+		e.limiter.release()
+
+		res.Success = err == nil
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
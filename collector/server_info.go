@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+type debugResponse struct {
+	State   int    `json:"state"`
+	Version string `json:"version"`
+}
+
+type ServerInfo struct {
+	logger   *log.Logger
+	url      *url.URL
+	upstream *upstream
+
+	*targetTracker
+
+	up prometheus.Gauge
+
+	info  *prometheus.Desc
+	state *prometheus.Desc
+}
+
+func init() {
+	Register("server_info", true, func(config Config) (Collector, error) {
+		return NewServerInfo(config), nil
+	})
+}
+
+// NewServerInfo creates a new ServerInfo
+func NewServerInfo(config Config) *ServerInfo {
+	subsystem := "server_info"
+	url := config.URL
+
+	upstream := newUpstream(config)
+
+	return &ServerInfo{
+		logger:   config.Logger,
+		url:      url,
+		upstream: upstream,
+
+		targetTracker: newTargetTracker(subsystem, upstream.endpointURL("/debug"), url),
+
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "up"),
+			Help: "Was the last scrape of the Typesense debug endpoint successful",
+		}),
+
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "server", "info"),
+			"A metric with a constant '1' value labeled by the version of the Typesense server, from /debug",
+			[]string{"cluster", "version"}, nil,
+		),
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "server", "state"),
+			"Raft state of the Typesense node, 1 for leader and 4 for follower, from /debug",
+			[]string{"cluster"}, nil,
+		),
+	}
+}
+
+func (c *ServerInfo) upGauge() prometheus.Gauge {
+	return c.up
+}
+
+// Describe set Prometheus metrics descriptions.
+func (c *ServerInfo) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.state
+
+	ch <- c.up.Desc()
+}
+
+// Update implements the Collector interface.
+func (c *ServerInfo) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	defer func() {
+		ch <- c.up
+	}()
+
+	start := time.Now()
+	resp, err := c.fetchAndDecodeDebug(ctx)
+	c.report(start, err)
+	if err != nil {
+		c.up.Set(0)
+		return err
+	}
+	c.up.Set(1)
+	c.upstream.markSuccess("server_info")
+
+	c.logger.WithField("duration", time.Since(start)).Debugln("fetched server info successfully")
+
+	ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, c.url.String(), resp.Version)
+	ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(resp.State), c.url.String())
+
+	return nil
+}
+
+func (c *ServerInfo) fetchAndDecodeDebug(ctx context.Context) (debugResponse, error) {
+	var resp debugResponse
+
+	bts, err := c.upstream.fetchJSON(ctx, "/debug", &resp, c.recordingPayloads())
+	if bts != nil {
+		c.recordPayload(bts)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	healthUnknown = "unknown"
+	healthUp      = "up"
+	healthDown    = "down"
+)
+
+// Target describes the state of a Typesense endpoint scraped by a collector, modeled after the
+// active targets returned by Prometheus' own targets API.
+type Target struct {
+	ScrapePool         string            `json:"scrapePool"`
+	ScrapeURL          string            `json:"scrapeUrl"`
+	Labels             map[string]string `json:"labels"`
+	Health             string            `json:"health"`
+	LastError          string            `json:"lastError"`
+	LastScrape         time.Time         `json:"lastScrape"`
+	LastScrapeDuration float64           `json:"lastScrapeDuration"`
+	// LastSuccess is when the last successful scrape started, zero if none succeeded yet.
+	LastSuccess time.Time `json:"lastSuccess"`
+	// ConsecutiveFailures counts the scrapes which failed since the last successful one.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+}
+
+// Payload is the raw body last fetched from a Typesense endpoint.
+type Payload struct {
+	ScrapePool string    `json:"scrapePool"`
+	ScrapeURL  string    `json:"scrapeUrl"`
+	FetchedAt  time.Time `json:"fetchedAt"`
+	Body       []byte    `json:"-"`
+}
+
+// TargetReporter is implemented by collectors which scrape a Typesense endpoint.
+type TargetReporter interface {
+	Target() Target
+
+	// RecordPayloads toggles retaining the last raw payload fetched from the target.
+	RecordPayloads(enabled bool)
+	// Payload returns the last raw payload, if payloads are being recorded.
+	Payload() (Payload, bool)
+}
+
+type targetTracker struct {
+	mtx    sync.RWMutex
+	target Target
+
+	recordPayloads bool
+	payload        *Payload
+}
+
+func newTargetTracker(pool string, scrapeURL *url.URL, clusterURL *url.URL) *targetTracker {
+	return &targetTracker{
+		target: Target{
+			ScrapePool: pool,
+			ScrapeURL:  scrapeURL.String(),
+			Labels: map[string]string{
+				"cluster": clusterURL.String(),
+			},
+			Health: healthUnknown,
+		},
+	}
+}
+
+// report records the outcome of a scrape which started at start.
+func (t *targetTracker) report(start time.Time, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.target.LastScrape = start
+	t.target.LastScrapeDuration = time.Since(start).Seconds()
+	if err != nil {
+		t.target.Health = healthDown
+		t.target.LastError = err.Error()
+		t.target.ConsecutiveFailures++
+	} else {
+		t.target.Health = healthUp
+		t.target.LastError = ""
+		t.target.LastSuccess = start
+		t.target.ConsecutiveFailures = 0
+	}
+}
+
+// Target returns a snapshot of the current target state.
+func (t *targetTracker) Target() Target {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	target := t.target
+	target.Labels = make(map[string]string, len(t.target.Labels))
+	for k, v := range t.target.Labels {
+		target.Labels[k] = v
+	}
+	return target
+}
+
+// RecordPayloads toggles retaining the last raw payload fetched from the target.
+func (t *targetTracker) RecordPayloads(enabled bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.recordPayloads = enabled
+	if !enabled {
+		t.payload = nil
+	}
+}
+
+// recordingPayloads returns whether payloads are being recorded.
+func (t *targetTracker) recordingPayloads() bool {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	return t.recordPayloads
+}
+
+// recordPayload retains body as the last payload if payloads are being recorded.
+func (t *targetTracker) recordPayload(body []byte) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if !t.recordPayloads {
+		return
+	}
+	t.payload = &Payload{
+		ScrapePool: t.target.ScrapePool,
+		ScrapeURL:  t.target.ScrapeURL,
+		FetchedAt:  time.Now(),
+		Body:       body,
+	}
+}
+
+// Payload returns the last raw payload, if payloads are being recorded.
+func (t *targetTracker) Payload() (Payload, bool) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	if t.payload == nil {
+		return Payload{}, false
+	}
+	return *t.payload, true
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter caps how long a Retry-After header can suppress requests to a node, so a bogus
+// value doesn't stop scraping for days.
+const maxRetryAfter = 10 * time.Minute
+
+// throttle tracks the Retry-After window of a Typesense node that answered 429, shared by the
+// collectors scraping it.
+type throttle struct {
+	mtx   sync.Mutex
+	until time.Time
+}
+
+// active returns until when requests to the node are suppressed, if they are.
+func (t *throttle) active() (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.until, time.Now().Before(t.until)
+}
+
+// set suppresses requests to the node for the window of retryAfter, the value of a Retry-After
+// header in seconds or as an HTTP date. Responses without a valid Retry-After don't suppress
+// requests.
+func (t *throttle) set(retryAfter string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	var until time.Time
+	retryAfter = strings.TrimSpace(retryAfter)
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		until = now.Add(time.Duration(seconds) * time.Second)
+	} else if date, err := http.ParseTime(retryAfter); err == nil {
+		until = date
+	} else {
+		return
+	}
+	if until.Sub(now) > maxRetryAfter {
+		until = now.Add(maxRetryAfter)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if until.After(t.until) {
+		t.until = until
+	}
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// trace returns a ClientTrace observing how long the DNS lookup, connect and TLS handshake of a
+// request to endpoint took, and how long Typesense took from receiving the request to its first
+// response byte. Phases which don't happen, e.g. for requests reusing a connection, aren't
+// observed, so slow networks can be told apart from a slow Typesense.
+func (u *upstream) trace(endpoint string) *httptrace.ClientTrace {
+	// Connects to several addresses may run concurrently, e.g. for dual-stack hosts.
+	var mtx sync.Mutex
+	starts := make(map[string]time.Time)
+	begin := func(phase string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		starts[phase] = time.Now()
+	}
+	end := func(phase string, err error) {
+		mtx.Lock()
+		start, ok := starts[phase]
+		mtx.Unlock()
+		if !ok || err != nil {
+			return
+		}
+		u.metrics.requestPhases.WithLabelValues(endpoint, u.url.String(), phase).Observe(time.Since(start).Seconds())
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { begin("dns") },
+		DNSDone:           func(info httptrace.DNSDoneInfo) { end("dns", info.Err) },
+		ConnectStart:      func(string, string) { begin("connect") },
+		ConnectDone:       func(_, _ string, err error) { end("connect", err) },
+		TLSHandshakeStart: func() { begin("tls") },
+		TLSHandshakeDone:  func(_ tls.ConnectionState, err error) { end("tls", err) },
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				begin("first_byte")
+			}
+		},
+		GotFirstResponseByte: func() { end("first_byte", nil) },
+	}
+}
@@ -0,0 +1,569 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpstreamMetrics holds exporter-side telemetry about the requests made to Typesense. A single
+// instance is shared by all collectors and registered once.
+type UpstreamMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	requestPhases   *prometheus.HistogramVec
+	lastDuration    *prometheus.GaugeVec
+	errors          *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+	responseSize    *prometheus.GaugeVec
+	lastSuccess     *prometheus.GaugeVec
+	scrapes         *prometheus.CounterVec
+	unknownFields   *prometheus.CounterVec
+	unparseableKeys *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	notReady        *prometheus.GaugeVec
+	throttled       *prometheus.CounterVec
+	authValid       *prometheus.GaugeVec
+	backoff         *prometheus.GaugeVec
+	skippedScrapes  *prometheus.CounterVec
+}
+
+// NewUpstreamMetrics creates a new UpstreamMetrics. With nativeHistograms, latencies are additionally
+// exposed as native histograms to scrapers negotiating the protobuf format.
+func NewUpstreamMetrics(nativeHistograms bool) *UpstreamMetrics {
+	subsystem := "exporter"
+
+	requestDurationOpts := prometheus.HistogramOpts{
+		Name:    prometheus.BuildFQName(namespace, subsystem, "upstream_request_duration_seconds"),
+		Help:    "Duration of HTTP requests made by the exporter to Typesense, including reading the body",
+		Buckets: prometheus.DefBuckets,
+	}
+	// The phases of a request are mostly well below the request duration, down to sub-millisecond
+	// DNS lookups and connects.
+	requestPhasesOpts := prometheus.HistogramOpts{
+		Name:    prometheus.BuildFQName(namespace, subsystem, "upstream_request_phase_seconds"),
+		Help:    "Duration of the phases of HTTP requests made by the exporter to Typesense (dns, connect, tls or first_byte)",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}
+	if nativeHistograms {
+		for _, opts := range []*prometheus.HistogramOpts{&requestDurationOpts, &requestPhasesOpts} {
+			opts.NativeHistogramBucketFactor = 1.1
+			opts.NativeHistogramMaxBucketNumber = 100
+			opts.NativeHistogramMinResetDuration = time.Hour
+		}
+	}
+
+	return &UpstreamMetrics{
+		requestDuration: prometheus.NewHistogramVec(requestDurationOpts, []string{"endpoint", "target"}),
+		requestPhases:   prometheus.NewHistogramVec(requestPhasesOpts, []string{"endpoint", "target", "phase"}),
+		lastDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_last_request_duration_seconds"),
+			Help: "Duration of the last HTTP request made by the exporter to each Typesense endpoint, including reading the body",
+		}, []string{"endpoint", "target"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_errors_total"),
+			Help: "Number of failed requests to Typesense by endpoint, HTTP status code and type of failure (http, parse, timeout or too_large)",
+		}, []string{"endpoint", "target", "code", "type"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_failures_total"),
+			Help: "Number of failed requests to each Typesense node by category (dns, connect, tls, timeout, auth, not_ready, http_status, decode, too_large or other)",
+		}, []string{"target", "category"}),
+		responseSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_response_size_bytes"),
+			Help: "Size in bytes of the last response body fetched from Typesense",
+		}, []string{"endpoint", "target"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "last_success_timestamp_seconds"),
+			Help: "Unix timestamp of the last successful scrape by each collector",
+		}, []string{"collector", "target"}),
+		scrapes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "scrapes_total"),
+			Help: "Number of scrapes of Typesense by each collector",
+		}, []string{"collector", "target"}),
+		unknownFields: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_unknown_fields_total"),
+			Help: "Number of responses from Typesense containing a field the exporter doesn't know, counted with strict decoding",
+		}, []string{"endpoint", "target", "field"}),
+		unparseableKeys: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_unparseable_keys_total"),
+			Help: "Number of keys in responses from Typesense which couldn't be parsed and were skipped, e.g. malformed per-endpoint stats keys",
+		}, []string{"endpoint", "target", "field"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_retries_total"),
+			Help: "Number of requests to Typesense retried after a transport error or a 502 or 504 response",
+		}, []string{"target", "endpoint"}),
+		notReady: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, "node", "not_ready"),
+			Help: "Whether the last response from each Typesense endpoint was a 503 saying the node isn't ready, by reason (lagging or queued_writes)",
+		}, []string{"target", "endpoint", "reason"}),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "upstream_throttled_total"),
+			Help: "Number of requests to Typesense answered with 429 or not sent while honoring its Retry-After",
+		}, []string{"target", "endpoint"}),
+		authValid: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "auth_valid"),
+			Help: "Whether Typesense accepted the exporter's credentials in the last response, 0 after a 401 or 403",
+		}, []string{"target"}),
+		backoff: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "target_backoff_seconds"),
+			Help: "Longest time scrapes of a collector of the Typesense node are skipped after its scrapes failed, 0 while all are healthy",
+		}, []string{"target"}),
+		skippedScrapes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, subsystem, "target_skipped_scrapes_total"),
+			Help: "Number of scrapes of the Typesense node skipped while backing off from it",
+		}, []string{"target"}),
+	}
+}
+
+// Describe set Prometheus metrics descriptions.
+func (m *UpstreamMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requestDuration.Describe(ch)
+	m.requestPhases.Describe(ch)
+	m.lastDuration.Describe(ch)
+	m.errors.Describe(ch)
+	m.failures.Describe(ch)
+	m.responseSize.Describe(ch)
+	m.lastSuccess.Describe(ch)
+	m.scrapes.Describe(ch)
+	m.unknownFields.Describe(ch)
+	m.unparseableKeys.Describe(ch)
+	m.retries.Describe(ch)
+	m.notReady.Describe(ch)
+	m.throttled.Describe(ch)
+	m.authValid.Describe(ch)
+	m.backoff.Describe(ch)
+	m.skippedScrapes.Describe(ch)
+}
+
+// Collect collects upstream request metrics.
+func (m *UpstreamMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requestDuration.Collect(ch)
+	m.requestPhases.Collect(ch)
+	m.lastDuration.Collect(ch)
+	m.errors.Collect(ch)
+	m.failures.Collect(ch)
+	m.responseSize.Collect(ch)
+	m.lastSuccess.Collect(ch)
+	m.scrapes.Collect(ch)
+	m.unknownFields.Collect(ch)
+	m.unparseableKeys.Collect(ch)
+	m.retries.Collect(ch)
+	m.notReady.Collect(ch)
+	m.throttled.Collect(ch)
+	m.authValid.Collect(ch)
+	m.backoff.Collect(ch)
+	m.skippedScrapes.Collect(ch)
+}
+
+// upstream performs requests against a single Typesense node.
+type upstream struct {
+	logger          *log.Logger
+	client          *http.Client
+	url             *url.URL
+	metrics         *UpstreamMetrics
+	maxResponseSize int64
+	strictDecoding  bool
+	retries         int
+	throttle        *throttle
+
+	// responses holds the last successfully decoded response of each endpoint, served while the
+	// node is throttled.
+	mtx       sync.Mutex
+	responses map[string]cachedResponse
+	// version is the server version last detected at versionAt.
+	version   string
+	versionAt time.Time
+}
+
+func newUpstream(config Config) *upstream {
+	return &upstream{
+		logger:          config.Logger,
+		client:          config.Client,
+		url:             config.URL,
+		metrics:         config.UpstreamMetrics,
+		maxResponseSize: config.MaxResponseSize,
+		strictDecoding:  config.StrictDecoding,
+		retries:         config.Retries,
+		throttle:        config.throttle,
+		responses:       make(map[string]cachedResponse),
+	}
+}
+
+// endpointURL returns the absolute URL of endpoint on the Typesense node.
+func (u *upstream) endpointURL(endpoint string) *url.URL {
+	eu := *u.url
+	eu.Path = path.Join(eu.Path, endpoint)
+	return &eu
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// countingReader counts the bytes read from r and remembers the first read error.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// fetchJSON GETs endpoint from the Typesense node and decodes the body into v while it is being
+// streamed. The raw body is only buffered and returned when keep is set, including when decoding
+// fails. With strict decoding, fields in the body that v has no field for fail the request.
+func (u *upstream) fetchJSON(ctx context.Context, endpoint string, v interface{}, keep bool) ([]byte, error) {
+	return u.fetchJSONQuery(ctx, endpoint, nil, v, keep)
+}
+
+// fetchJSONQuery is like fetchJSON, adding query to the request URL. Metrics are still labeled by
+// endpoint alone.
+func (u *upstream) fetchJSONQuery(ctx context.Context, endpoint string, query url.Values, v interface{}, keep bool) ([]byte, error) {
+	eu := u.endpointURL(endpoint)
+	eu.RawQuery = query.Encode()
+
+	if until, ok := u.throttle.active(); ok {
+		u.metrics.throttled.WithLabelValues(u.url.String(), endpoint).Inc()
+		return nil, u.cachedResponse(endpoint, eu.RawQuery, v, fmt.Errorf("throttled by Typesense until %s", until.Format(time.RFC3339)))
+	}
+
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start).Seconds()
+		u.metrics.requestDuration.WithLabelValues(endpoint, u.url.String()).Observe(duration)
+		u.metrics.lastDuration.WithLabelValues(endpoint, u.url.String()).Set(duration)
+	}()
+
+	res, err := u.get(ctx, endpoint, eu.String())
+	if err != nil {
+		u.countError(endpoint, "", err)
+		return nil, fmt.Errorf("failed to get %s: %w", eu.String(), classify(err))
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			u.logger.WithError(err).Warnln("failed to close http.Client")
+		}
+	}()
+
+	code := strconv.Itoa(res.StatusCode)
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		u.metrics.authValid.WithLabelValues(u.url.String()).Set(0)
+		u.metrics.errors.WithLabelValues(endpoint, u.url.String(), code, "http").Inc()
+		u.countFailure("auth")
+		return nil, &StatusError{Endpoint: endpoint, StatusCode: res.StatusCode}
+	}
+	if res.StatusCode == http.StatusServiceUnavailable {
+		if reason, message, ok := notReadyReason(res.Body); ok {
+			u.metrics.errors.WithLabelValues(endpoint, u.url.String(), code, "http").Inc()
+			u.countFailure("not_ready")
+			u.setNotReady(endpoint, reason)
+			return nil, &NotReadyError{Endpoint: endpoint, Reason: reason, Message: message}
+		}
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		u.metrics.throttled.WithLabelValues(u.url.String(), endpoint).Inc()
+		u.throttle.set(res.Header.Get("Retry-After"))
+		u.countError(endpoint, code, nil)
+		return nil, u.cachedResponse(endpoint, eu.RawQuery, v, &StatusError{Endpoint: endpoint, StatusCode: res.StatusCode})
+	}
+	if res.StatusCode != http.StatusOK {
+		u.countError(endpoint, code, nil)
+		return nil, &StatusError{Endpoint: endpoint, StatusCode: res.StatusCode}
+	}
+
+	u.setNotReady(endpoint, "")
+	u.metrics.authValid.WithLabelValues(u.url.String()).Set(1)
+
+	var bodyReader io.Reader = res.Body
+	if u.maxResponseSize > 0 {
+		// Read one byte past the limit to tell a body of exactly the limit from a larger one.
+		bodyReader = io.LimitReader(res.Body, u.maxResponseSize+1)
+	}
+	body := &countingReader{r: bodyReader}
+	var r io.Reader = body
+	var buf *bytes.Buffer
+	if keep || u.strictDecoding {
+		buf = bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer bufferPool.Put(buf)
+		r = io.TeeReader(body, buf)
+	}
+
+	decodeErr := json.NewDecoder(r).Decode(v)
+	// Drain the rest of the body so the connection can be reused.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil && body.err == nil {
+		body.err = err
+	}
+	u.metrics.responseSize.WithLabelValues(endpoint, u.url.String()).Set(float64(body.n))
+
+	var bts []byte
+	if keep {
+		bts = append([]byte(nil), buf.Bytes()...)
+	}
+
+	if u.maxResponseSize > 0 && body.n > u.maxResponseSize {
+		u.metrics.errors.WithLabelValues(endpoint, u.url.String(), code, "too_large").Inc()
+		u.countFailure("too_large")
+		return bts, fmt.Errorf("response body from %s exceeds %d bytes", endpoint, u.maxResponseSize)
+	}
+	if body.err != nil {
+		u.countError(endpoint, code, body.err)
+		return bts, classify(body.err)
+	}
+	if decodeErr == nil && u.strictDecoding {
+		decodeErr = u.checkUnknownFields(endpoint, buf.Bytes(), v)
+	}
+	if decodeErr != nil {
+		u.metrics.errors.WithLabelValues(endpoint, u.url.String(), code, "parse").Inc()
+		u.countFailure("decode")
+		return bts, &kindError{kind: ErrDecode, err: decodeErr}
+	}
+
+	u.mtx.Lock()
+	u.responses[endpoint] = cachedResponse{query: eu.RawQuery, value: reflect.ValueOf(v).Elem().Interface(), at: time.Now()}
+	u.mtx.Unlock()
+	return bts, nil
+}
+
+// maxCachedResponseAge is how long a response is served while the node is throttled, matching the
+// longest Retry-After honored.
+const maxCachedResponseAge = maxRetryAfter
+
+// cachedResponse is the last response decoded from an endpoint.
+type cachedResponse struct {
+	// query is the query string the response was requested with, e.g. the offset of a page.
+	query string
+	value interface{}
+	at    time.Time
+}
+
+// cachedResponse sets v to the last response decoded from endpoint while the node is throttled,
+// returning err if there is none for query or it is older than maxCachedResponseAge.
+func (u *upstream) cachedResponse(endpoint, query string, v interface{}, err error) error {
+	u.mtx.Lock()
+	cached, ok := u.responses[endpoint]
+	if ok && time.Since(cached.at) > maxCachedResponseAge {
+		delete(u.responses, endpoint)
+		ok = false
+	}
+	u.mtx.Unlock()
+	if !ok || cached.query != query || reflect.TypeOf(cached.value) != reflect.TypeOf(v).Elem() {
+		return err
+	}
+	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(cached.value))
+	u.logger.WithError(err).WithField("endpoint", endpoint).Debugln("serving cached response while throttled")
+	return nil
+}
+
+// retryBackoff is the delay before the first retry of a request, doubling with each further retry.
+const retryBackoff = 100 * time.Millisecond
+
+// get GETs rawURL, retrying up to the configured number of times on transport errors other than
+// timeouts and on 502 and 504 responses, which proxies in front of Typesense answer with while it
+// restarts.
+func (u *upstream) get(ctx context.Context, endpoint, rawURL string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, u.trace(endpoint)), http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := u.client.Do(req)
+		if attempt >= u.retries || !retryable(res, err) || ctx.Err() != nil {
+			return res, err
+		}
+		if res != nil {
+			// Drain the body so the connection can be reused for the retry.
+			if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+				u.logger.WithError(err).Debugln("failed to drain response before retrying")
+			}
+			if err := res.Body.Close(); err != nil {
+				u.logger.WithError(err).Debugln("failed to close response before retrying")
+			}
+		}
+
+		u.metrics.retries.WithLabelValues(u.url.String(), endpoint).Inc()
+		u.logger.WithFields(log.Fields{
+			"endpoint": endpoint,
+			"attempt":  attempt + 1,
+		}).Debugln("retrying request to Typesense")
+
+		timer := time.NewTimer(retryBackoff << uint(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return !(errors.As(err, &netErr) && netErr.Timeout())
+	}
+	return res.StatusCode == http.StatusBadGateway || res.StatusCode == http.StatusGatewayTimeout
+}
+
+// notReadyReasons maps the reasons exported by typesense_node_not_ready to substrings of the
+// messages Typesense answers 503 with, e.g. "Not Ready or Lagging" from followers catching up.
+var notReadyReasons = []struct {
+	reason   string
+	messages []string
+}{
+	{"queued_writes", []string{"queued_writes", "queued writes"}},
+	{"lagging", []string{"lagging", "not ready"}},
+}
+
+// notReadyReason reads the message of a 503 response and tells whether Typesense answered it
+// because the node isn't ready rather than e.g. a proxy in front of it being unavailable.
+func notReadyReason(body io.Reader) (reason, message string, ok bool) {
+	var res struct {
+		Message       string `json:"message"`
+		ResourceError string `json:"resource_error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, 4096)).Decode(&res); err != nil {
+		return "", "", false
+	}
+	message = res.Message
+	if message == "" {
+		message = res.ResourceError
+	}
+	lower := strings.ToLower(message)
+	for _, r := range notReadyReasons {
+		for _, m := range r.messages {
+			if strings.Contains(lower, m) {
+				return r.reason, message, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// setNotReady records that endpoint answered that the Typesense node isn't ready for reason, or
+// answered normally if reason is empty.
+func (u *upstream) setNotReady(endpoint, reason string) {
+	for _, r := range notReadyReasons {
+		value := 0.0
+		if r.reason == reason {
+			value = 1
+		}
+		u.metrics.notReady.WithLabelValues(u.url.String(), endpoint, r.reason).Set(value)
+	}
+}
+
+// checkUnknownFields counts the fields in body that v has no field for, returning an error if
+// there are any.
+func (u *upstream) checkUnknownFields(endpoint string, body []byte, v interface{}) error {
+	unknown, err := unknownFields(body, v)
+	if err != nil {
+		return err
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	for _, field := range unknown {
+		u.metrics.unknownFields.WithLabelValues(endpoint, u.url.String(), field).Inc()
+	}
+	return fmt.Errorf("unknown fields in response from %s: %s", endpoint, strings.Join(unknown, ", "))
+}
+
+// countUnparseableKey records a key of field in the response from endpoint which couldn't be parsed.
+func (u *upstream) countUnparseableKey(endpoint, field string) {
+	u.metrics.unparseableKeys.WithLabelValues(endpoint, u.url.String(), field).Inc()
+}
+
+// setBackoff records how long scrapes of the Typesense node are skipped.
+func (u *upstream) setBackoff(delay time.Duration) {
+	u.metrics.backoff.WithLabelValues(u.url.String()).Set(delay.Seconds())
+}
+
+// countSkippedScrape records a scrape of the Typesense node skipped while backing off.
+func (u *upstream) countSkippedScrape() {
+	u.metrics.skippedScrapes.WithLabelValues(u.url.String()).Inc()
+}
+
+// countScrape records that collector started a scrape of the Typesense node.
+func (u *upstream) countScrape(collector string) {
+	u.metrics.scrapes.WithLabelValues(collector, u.url.String()).Inc()
+}
+
+// markSuccess records that collector successfully scraped the Typesense node. Scrapes served
+// cached responses while the node is throttled don't count.
+func (u *upstream) markSuccess(collector string) {
+	if _, ok := u.throttle.active(); ok {
+		return
+	}
+	u.metrics.lastSuccess.WithLabelValues(collector, u.url.String()).SetToCurrentTime()
+}
+
+// countError records a failed request to endpoint, classifying err as a timeout where possible.
+func (u *upstream) countError(endpoint, code string, err error) {
+	errType := "http"
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		errType = "timeout"
+	}
+	u.metrics.errors.WithLabelValues(endpoint, u.url.String(), code, errType).Inc()
+	u.countFailure(failureCategory(err))
+}
+
+// countFailure records a failed request to the Typesense node in category.
+func (u *upstream) countFailure(category string) {
+	u.metrics.failures.WithLabelValues(u.url.String(), category).Inc()
+}
+
+// failureCategory tells why a request failed with err, or because of its HTTP status if err is nil.
+func failureCategory(err error) string {
+	if err == nil {
+		return "http_status"
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || strings.Contains(err.Error(), "tls: ") {
+		return "tls"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return "connect"
+	}
+	return "other"
+}
@@ -0,0 +1,329 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+type testResponse struct {
+	Used int `json:"used"`
+}
+
+// scriptedServer answers the requests to it with the given statuses in turn, the last one
+// repeating, and body for 200 responses.
+type scriptedServer struct {
+	*httptest.Server
+
+	mtx      sync.Mutex
+	statuses []int
+	requests int
+}
+
+func newScriptedServer(t *testing.T, body string, statuses ...int) *scriptedServer {
+	s := &scriptedServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.Lock()
+		status := s.statuses[len(s.statuses)-1]
+		if s.requests < len(s.statuses) {
+			status = s.statuses[s.requests]
+		}
+		s.requests++
+		s.mtx.Unlock()
+
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(body))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// count returns how many requests were made.
+func (s *scriptedServer) count() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.requests
+}
+
+func newTestUpstream(t *testing.T, rawURL string, config Config) *upstream {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Logger = log.New()
+	config.Client = &http.Client{Timeout: time.Second}
+	config.URL = u
+	config.UpstreamMetrics = NewUpstreamMetrics(false)
+	config.throttle = &throttle{}
+	return newUpstream(config)
+}
+
+func counterValue(t *testing.T, c interface{ Write(*dto.Metric) error }) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestFetchJSONRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		statuses []int
+		requests int
+		status   int
+	}{
+		{name: "recovers within the retries", retries: 2, statuses: []int{502, 504, 200}, requests: 3},
+		{name: "gives up after the retries", retries: 2, statuses: []int{502}, requests: 3, status: 502},
+		{name: "no retries", retries: 0, statuses: []int{502, 200}, requests: 1, status: 502},
+		{name: "other statuses aren't retried", retries: 2, statuses: []int{500, 200}, requests: 1, status: 500},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScriptedServer(t, `{"used": 1}`, tt.statuses...)
+			u := newTestUpstream(t, s.URL, Config{Retries: tt.retries})
+
+			var v testResponse
+			_, err := u.fetchJSON(context.Background(), "/metrics.json", &v, false)
+			var statusErr *StatusError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.status != 0 && !errors.As(err, &statusErr):
+				t.Fatalf("expected a StatusError, got %v", err)
+			case tt.status != 0 && statusErr.StatusCode != tt.status:
+				t.Fatalf("got status %d, want %d", statusErr.StatusCode, tt.status)
+			}
+			if n := s.count(); n != tt.requests {
+				t.Errorf("got %d requests, want %d", n, tt.requests)
+			}
+			retries := counterValue(t, u.metrics.retries.WithLabelValues(s.URL, "/metrics.json"))
+			if want := float64(tt.requests - 1); retries != want {
+				t.Errorf("counted %v retries, want %v", retries, want)
+			}
+		})
+	}
+}
+
+func TestFetchJSONMaxResponseSize(t *testing.T) {
+	body := `{"used": 12345}`
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr bool
+	}{
+		{name: "no limit", limit: 0},
+		{name: "body of exactly the limit", limit: int64(len(body))},
+		{name: "body over the limit", limit: int64(len(body)) - 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScriptedServer(t, body, http.StatusOK)
+			u := newTestUpstream(t, s.URL, Config{MaxResponseSize: tt.limit})
+
+			var v testResponse
+			_, err := u.fetchJSON(context.Background(), "/metrics.json", &v, false)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "exceeds") {
+					t.Fatalf("expected a size error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Used != 12345 {
+				t.Errorf("decoded %+v", v)
+			}
+		})
+	}
+}
+
+func TestFetchJSONStrictDecoding(t *testing.T) {
+	body := `{"used": 1, "new_field": 2}`
+	for _, strict := range []bool{false, true} {
+		s := newScriptedServer(t, body, http.StatusOK)
+		u := newTestUpstream(t, s.URL, Config{StrictDecoding: strict})
+
+		var v testResponse
+		_, err := u.fetchJSON(context.Background(), "/metrics.json", &v, false)
+		if !strict {
+			if err != nil {
+				t.Errorf("unexpected error without strict decoding: %s", err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrDecode) || !strings.Contains(err.Error(), "new_field") {
+			t.Errorf("expected a decode error naming new_field, got %v", err)
+		}
+		if n := counterValue(t, u.metrics.unknownFields.WithLabelValues("/metrics.json", s.URL, "new_field")); n != 1 {
+			t.Errorf("counted %v unknown fields, want 1", n)
+		}
+	}
+}
+
+func TestFetchJSONErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		timeout time.Duration
+		is      error
+		status  int
+		reason  string
+	}{
+		{
+			name:    "unauthorized",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) },
+			is:      ErrUnauthorized,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "forbidden",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusForbidden) },
+			is:      ErrUnauthorized,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			status:  http.StatusNotFound,
+		},
+		{
+			name: "not ready",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"message": "Not Ready or Lagging"}`))
+			},
+			is:     ErrNotReady,
+			reason: "lagging",
+		},
+		{
+			name: "unavailable proxy",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("no healthy upstream"))
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:    "invalid JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"used": `)) },
+			is:      ErrDecode,
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			timeout: 50 * time.Millisecond,
+			is:      ErrTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := httptest.NewServer(tt.handler)
+			defer s.Close()
+			u := newTestUpstream(t, s.URL, Config{})
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			var v testResponse
+			_, err := u.fetchJSON(ctx, "/metrics.json", &v, false)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("expected an error matching %q, got %v", tt.is, err)
+			}
+			for _, other := range []error{ErrUnauthorized, ErrNotReady, ErrDecode, ErrTimeout} {
+				if other != tt.is && errors.Is(err, other) {
+					t.Errorf("error %v unexpectedly matches %q", err, other)
+				}
+			}
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) != (tt.status != 0) || (tt.status != 0 && statusErr.StatusCode != tt.status) {
+				t.Errorf("got error %v, want status %d", err, tt.status)
+			}
+			var notReadyErr *NotReadyError
+			if errors.As(err, &notReadyErr) != (tt.reason != "") || (tt.reason != "" && notReadyErr.Reason != tt.reason) {
+				t.Errorf("got error %v, want not ready reason %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestFetchJSONCachedResponse(t *testing.T) {
+	s := newScriptedServer(t, `{"used": 7}`, http.StatusOK, http.StatusOK, http.StatusTooManyRequests)
+	u := newTestUpstream(t, s.URL, Config{})
+	ctx := context.Background()
+
+	// Pages of an endpoint replace each other, so only the last one is kept.
+	for _, offset := range []string{"0", "2"} {
+		var v testResponse
+		if _, err := u.fetchJSONQuery(ctx, "/collections", url.Values{"offset": {offset}}, &v, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(u.responses); n != 1 {
+		t.Fatalf("cached %d responses, want 1", n)
+	}
+
+	tests := []struct {
+		name    string
+		offset  string
+		age     time.Duration
+		wantErr bool
+	}{
+		{name: "last response", offset: "2"},
+		{name: "other page", offset: "0", wantErr: true},
+		{name: "too old", offset: "2", age: maxCachedResponseAge + time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u.mtx.Lock()
+			cached := u.responses["/collections"]
+			cached.at = time.Now().Add(-tt.age)
+			u.responses["/collections"] = cached
+			u.mtx.Unlock()
+
+			var v testResponse
+			_, err := u.fetchJSONQuery(ctx, "/collections", url.Values{"offset": {tt.offset}}, &v, false)
+			switch {
+			case tt.wantErr && err == nil:
+				t.Fatal("expected an error")
+			case !tt.wantErr && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case !tt.wantErr && v.Used != 7:
+				t.Errorf("served %+v, want the cached response", v)
+			}
+		})
+	}
+	if _, ok := u.responses["/collections"]; ok {
+		t.Error("expected the response which became too old to be dropped")
+	}
+}
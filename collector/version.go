@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionCacheTTL is how long a detected server version is used before asking /debug again, so
+// upgrades are picked up without a request per scrape.
+const versionCacheTTL = 5 * time.Minute
+
+// VersionedCollector is implemented by collectors depending on endpoints which only exist on newer
+// Typesense releases. They are skipped while the detected server version is older.
+type VersionedCollector interface {
+	Collector
+
+	// MinServerVersion returns the first Typesense release serving the collector's endpoints, e.g.
+	// "0.25.0".
+	MinServerVersion() string
+}
+
+// parseVersion parses the leading numeric components of a Typesense version such as "0.23.1" or
+// "v0.25.0.rc30". It returns false if there are none.
+func parseVersion(s string) ([]int, bool) {
+	var version []int
+	for _, part := range strings.Split(strings.TrimPrefix(s, "v"), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		version = append(version, n)
+	}
+	return version, len(version) > 0
+}
+
+// versionAtLeast reports whether version is the same as or newer than min. Versions which can't be
+// parsed are assumed to be new enough.
+func versionAtLeast(version, min string) bool {
+	v, ok := parseVersion(version)
+	if !ok {
+		return true
+	}
+	m, ok := parseVersion(min)
+	if !ok {
+		return true
+	}
+	for i := range m {
+		var n int
+		if i < len(v) {
+			n = v[i]
+		}
+		if n != m[i] {
+			return n > m[i]
+		}
+	}
+	return true
+}
+
+// serverVersion returns the version reported by the Typesense node's /debug endpoint, detected at
+// most once every versionCacheTTL.
+func (u *upstream) serverVersion(ctx context.Context) (string, error) {
+	u.mtx.Lock()
+	version, at := u.version, u.versionAt
+	u.mtx.Unlock()
+	if !at.IsZero() && time.Since(at) < versionCacheTTL {
+		return version, nil
+	}
+
+	var resp debugResponse
+	if _, err := u.fetchJSON(ctx, "/debug", &resp, false); err != nil {
+		return "", err
+	}
+
+	u.mtx.Lock()
+	u.version, u.versionAt = resp.Version, time.Now()
+	u.mtx.Unlock()
+	return resp.Version, nil
+}
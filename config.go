@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+
+	toml "github.com/BurntSushi/toml"
+	flag "github.com/namsral/flag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// envVarPattern matches ${VAR} and ${VAR:-default} references in config file values.
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv replaces ${VAR} references in s with the value of the environment variable VAR,
+// or with default in ${VAR:-default} when VAR is unset or empty.
+func interpolateEnv(s string) (string, error) {
+	var err error
+	out := envVarPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envVarPattern.FindStringSubmatch(ref)
+		if value := os.Getenv(m[1]); value != "" {
+			return value
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %s referenced but not set", m[1])
+		}
+		return ""
+	})
+	return out, err
+}
+
+// parseConfigFile parses b as TOML or JSON, as indicated by the extension of path, or as YAML
+// otherwise.
+func parseConfigFile(path string, b []byte) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	default:
+		err = yaml.Unmarshal(b, &values)
+	}
+	return values, err
+}
+
+// instanceConfig configures an additional exporter served on its own telemetry path, scraping
+// another Typesense cluster with its own credentials. Everything else is shared with the main
+// exporter.
+type instanceConfig struct {
+	TelemetryPath string
+	URL           string
+	APIKey        string
+	// Timeout is 0 to use typesense-timeout.
+	Timeout time.Duration
+	// Collectors is empty to run the collectors enabled by flags.
+	Collectors []string
+	// CollectorToggles enables or disables collectors by name on top of Collectors, as the
+	// collector-<name> keys of the instance.
+	CollectorToggles map[string]bool
+}
+
+// collectors returns the collectors of the instance, starting from defaults unless Collectors is
+// set.
+func (inst instanceConfig) collectors(defaults []string) []string {
+	enabled := make(map[string]bool)
+	base := inst.Collectors
+	if len(base) == 0 {
+		base = defaults
+	}
+	for _, name := range base {
+		enabled[name] = true
+	}
+	for name, on := range inst.CollectorToggles {
+		enabled[name] = on
+	}
+
+	names := make([]string, 0, len(enabled))
+	for name, on := range enabled {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseInstances parses the instances list of a config file, each entry mapping telemetry-path,
+// typesense-url, typesense-api-key and optionally typesense-timeout, collectors and
+// collector-<name> to values.
+func parseInstances(path string, raw interface{}) ([]instanceConfig, error) {
+	var entries []interface{}
+	switch raw := raw.(type) {
+	case []interface{}:
+		entries = raw
+	case []map[string]interface{}:
+		for _, entry := range raw {
+			entries = append(entries, entry)
+		}
+	default:
+		return nil, fmt.Errorf("instances in %s must be a list", path)
+	}
+
+	instances := make([]instanceConfig, 0, len(entries))
+	for i, entry := range entries {
+		var values map[string]interface{}
+		switch entry := entry.(type) {
+		case map[string]interface{}:
+			values = entry
+		case map[interface{}]interface{}:
+			values = make(map[string]interface{}, len(entry))
+			for k, v := range entry {
+				values[fmt.Sprint(k)] = v
+			}
+		default:
+			return nil, fmt.Errorf("instance %d in %s must be a mapping", i+1, path)
+		}
+
+		var inst instanceConfig
+		for key, v := range values {
+			var value string
+			switch v := v.(type) {
+			case string:
+				var err error
+				if value, err = interpolateEnv(v); err != nil {
+					return nil, fmt.Errorf("invalid value for %s of instance %d in %s: %s", key, i+1, path, err)
+				}
+			case []interface{}:
+				if key != "collectors" {
+					return nil, fmt.Errorf("invalid value for %s of instance %d in %s: must be a string", key, i+1, path)
+				}
+				parts := make([]string, 0, len(v))
+				for _, part := range v {
+					parts = append(parts, fmt.Sprint(part))
+				}
+				value = strings.Join(parts, ",")
+			default:
+				value = fmt.Sprint(v)
+			}
+
+			switch key {
+			case "telemetry-path":
+				inst.TelemetryPath = value
+			case "typesense-url":
+				inst.URL = value
+			case "typesense-api-key":
+				inst.APIKey = value
+			case "typesense-timeout":
+				timeout, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for %s of instance %d in %s: %s", key, i+1, path, err)
+				}
+				inst.Timeout = timeout
+			case "collectors":
+				for _, name := range strings.Split(value, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						inst.Collectors = append(inst.Collectors, name)
+					}
+				}
+			default:
+				name := strings.Replace(strings.TrimPrefix(key, "collector-"), "-", "_", -1)
+				if _, ok := collector.Collectors()[name]; !ok || !strings.HasPrefix(key, "collector-") {
+					return nil, fmt.Errorf("unknown key %q in instance %d in %s", key, i+1, path)
+				}
+				on, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for %s of instance %d in %s: %s", key, i+1, path, err)
+				}
+				if inst.CollectorToggles == nil {
+					inst.CollectorToggles = make(map[string]bool)
+				}
+				inst.CollectorToggles[name] = on
+			}
+		}
+		if inst.TelemetryPath == "" || inst.URL == "" || inst.APIKey == "" {
+			return nil, fmt.Errorf("instance %d in %s needs telemetry-path, typesense-url and typesense-api-key", i+1, path)
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// loadConfigFile sets the flags in fs from the YAML, TOML or JSON file at path, which maps flag names
+// to values. Flags already set on the command line or in the environment take precedence over the
+// file. The instances key configures additional exporter instances, which are returned.
+func loadConfigFile(fs *flag.FlagSet, path string) ([]instanceConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := parseConfigFile(path, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	var instances []instanceConfig
+	if raw, ok := values["instances"]; ok {
+		delete(values, "instances")
+		if instances, err = parseInstances(path, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, v := range values {
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag %q in %s", name, path)
+		}
+		if set[name] {
+			continue
+		}
+
+		var value string
+		switch v := v.(type) {
+		case string:
+			value, err = interpolateEnv(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s in %s: %s", name, path, err)
+			}
+		case bool, int, int64, uint64, float64, json.Number:
+			value = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("invalid value for %s in %s: must be a string, number or boolean", name, path)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s in %s: %s", name, path, err)
+		}
+	}
+	return instances, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type grafanaDashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	Timezone      string            `json:"timezone"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label"`
+	Type       string             `json:"type"`
+	Query      string             `json:"query"`
+	Datasource *grafanaDatasource `json:"datasource,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	Refresh    int                `json:"refresh,omitempty"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int                `json:"id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type"`
+	Datasource  *grafanaDatasource `json:"datasource,omitempty"`
+	GridPos     grafanaGridPos     `json:"gridPos"`
+	Collapsed   bool               `json:"collapsed,omitempty"`
+	Panels      []grafanaPanel     `json:"panels,omitempty"`
+	Targets     []grafanaTarget    `json:"targets,omitempty"`
+	FieldConfig *grafanaFieldConf  `json:"fieldConfig,omitempty"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+type grafanaFieldConf struct {
+	Defaults grafanaFieldDefaults `json:"defaults"`
+}
+
+type grafanaFieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+// newDashboard generates a Grafana dashboard with a panel for each of the metric families, grouped
+// in a row per subsystem. Counters are graphed as rates, and sampled _min, _max and _avg aggregates
+// in the panel of the metric they aggregate; info metrics, which only carry labels, are left out.
+func newDashboard(descs []metricDesc) grafanaDashboard {
+	datasource := &grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+	dashboard := grafanaDashboard{
+		Title:         "Typesense",
+		UID:           "typesense-exporter",
+		Tags:          []string{"typesense"},
+		Timezone:      "browser",
+		SchemaVersion: 36,
+		Refresh:       "30s",
+		Time:          grafanaTimeRange{From: "now-1h", To: "now"},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{
+				Name:  "datasource",
+				Label: "Data source",
+				Type:  "datasource",
+				Query: "prometheus",
+			},
+			{
+				Name:       "cluster",
+				Label:      "Cluster",
+				Type:       "query",
+				Query:      "label_values(cluster)",
+				Datasource: datasource,
+				Multi:      true,
+				IncludeAll: true,
+				Refresh:    2,
+			},
+		}},
+	}
+
+	names := make(map[string]bool, len(descs))
+	for _, d := range descs {
+		names[d.Name] = true
+	}
+	aggregates := make(map[string][]metricDesc)
+	var graphed []metricDesc
+	for _, d := range descs {
+		if strings.HasSuffix(d.Name, "_info") {
+			continue
+		}
+		if i := strings.LastIndex(d.Name, "_"); i > 0 && names[d.Name[:i]] {
+			switch d.Name[i+1:] {
+			case "min", "max", "avg":
+				aggregates[d.Name[:i]] = append(aggregates[d.Name[:i]], d)
+				continue
+			}
+		}
+		graphed = append(graphed, d)
+	}
+
+	id := 0
+	y := 0
+	row := ""
+	x := 0
+	for _, d := range graphed {
+		if subsystem := metricSubsystem(d.Name); subsystem != row {
+			row = subsystem
+			if x > 0 {
+				y += 8
+				x = 0
+			}
+			id++
+			dashboard.Panels = append(dashboard.Panels, grafanaPanel{
+				ID:      id,
+				Title:   strings.Replace(row, "_", " ", -1),
+				Type:    "row",
+				GridPos: grafanaGridPos{X: 0, Y: y, W: 24, H: 1},
+			})
+			y++
+		}
+
+		id++
+		dashboard.Panels = append(dashboard.Panels, grafanaPanel{
+			ID:          id,
+			Title:       d.Name,
+			Description: d.Help,
+			Type:        "timeseries",
+			Datasource:  datasource,
+			GridPos:     grafanaGridPos{X: x, Y: y, W: 12, H: 8},
+			Targets:     panelTargets(d, aggregates[d.Name]),
+			FieldConfig: &grafanaFieldConf{Defaults: grafanaFieldDefaults{Unit: panelUnit(d.Name)}},
+		})
+		if x == 0 {
+			x = 12
+		} else {
+			x = 0
+			y += 8
+		}
+	}
+	return dashboard
+}
+
+// metricSubsystems maps metric name prefixes to the subsystem, usually a collector, they belong to.
+var metricSubsystems = []struct {
+	prefix    string
+	subsystem string
+}{
+	{"typesense_api_stats_", "api_stats"},
+	{"typesense_cluster_metrics_", "cluster_metrics"},
+	{"typesense_collections_", "collections"},
+	{"typesense_collection_", "collections"},
+	{"typesense_server_", "server_info"},
+	{"typesense_exporter_", "exporter"},
+}
+
+// metricSubsystem returns the subsystem of a typesense_<subsystem>_<name> metric.
+func metricSubsystem(name string) string {
+	for _, s := range metricSubsystems {
+		if strings.HasPrefix(name, s.prefix) {
+			return s.subsystem
+		}
+	}
+	return strings.SplitN(strings.TrimPrefix(name, "typesense_"), "_", 2)[0]
+}
+
+// panelTargets returns the queries graphing d and its aggregates.
+func panelTargets(d metricDesc, aggregates []metricDesc) []grafanaTarget {
+	targets := []grafanaTarget{panelTarget(d, "")}
+	for _, a := range aggregates {
+		targets = append(targets, panelTarget(a, strings.TrimPrefix(a.Name, d.Name+"_")))
+	}
+	for i := range targets {
+		targets[i].RefID = string(rune('A' + i))
+	}
+	return targets
+}
+
+// panelTarget returns the query graphing d, with suffix appended to its legend.
+func panelTarget(d metricDesc, suffix string) grafanaTarget {
+	selector := ""
+	var legend []string
+	for _, label := range d.Labels {
+		if label == "cluster" {
+			selector = `{cluster=~"$cluster"}`
+		}
+		legend = append(legend, "{{"+label+"}}")
+	}
+	if suffix != "" {
+		legend = append(legend, suffix)
+	}
+
+	expr := d.Name + selector
+	if strings.HasSuffix(d.Name, "_total") || strings.HasSuffix(d.Name, "_total_scrapes") {
+		expr = fmt.Sprintf("rate(%s[$__rate_interval])", expr)
+	}
+	return grafanaTarget{
+		Expr:         expr,
+		LegendFormat: strings.Join(legend, " "),
+	}
+}
+
+func panelUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_timestamp_seconds"):
+		return "dateTimeAsIso"
+	case strings.Contains(name, "_seconds"):
+		return "s"
+	case strings.Contains(name, "_bytes"):
+		return "bytes"
+	case strings.Contains(name, "_per_second"):
+		return "reqps"
+	case strings.HasSuffix(name, "_total"), strings.HasSuffix(name, "_total_scrapes"):
+		return "ops"
+	case strings.HasSuffix(name, "_ratio"):
+		return "percentunit"
+	}
+	return "short"
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// metricDesc describes a metric family a collector can emit.
+type metricDesc struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+	// Source is the Typesense endpoint the metric is read from, empty for the exporter's own metrics.
+	Source string `json:"source,omitempty"`
+}
+
+// descPattern matches the string representation of a prometheus.Desc, which is the only way to get
+// at its name, help and labels.
+var descPattern = regexp.MustCompile(`^Desc\{fqName: "((?:[^"\\]|\\.)*)", help: "((?:[^"\\]|\\.)*)", constLabels: \{.*\}, variableLabels: \[(.*)\]\}$`)
+
+// sourcePattern matches the Typesense endpoint named at the end of help strings.
+var sourcePattern = regexp.MustCompile(`, from (/\S+)$`)
+
+// describe returns the metric families cs describe, sorted by name. Descriptors don't carry the
+// metric type, so counters are told apart from gauges by their _total suffix.
+func describe(cs ...prometheus.Collector) []metricDesc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		for _, c := range cs {
+			c.Describe(ch)
+		}
+		close(ch)
+	}()
+
+	seen := make(map[string]bool)
+	var descs []metricDesc
+	for d := range ch {
+		m := descPattern.FindStringSubmatch(d.String())
+		if m == nil || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		help := strings.Replace(m[2], `\"`, `"`, -1)
+		desc := metricDesc{
+			Name:   m[1],
+			Type:   "gauge",
+			Help:   help,
+			Labels: strings.Fields(m[3]),
+		}
+		if strings.HasSuffix(desc.Name, "_total") || strings.HasSuffix(desc.Name, "_total_scrapes") {
+			desc.Type = "counter"
+		}
+		if s := sourcePattern.FindStringSubmatch(help); s != nil {
+			desc.Source = s[1]
+		}
+		descs = append(descs, desc)
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Name < descs[j].Name
+	})
+	return descs
+}
+
+// gatheredDescs returns the metric families gathered from g, which unlike descriptors carry their
+// type, sorted by name.
+func gatheredDescs(g prometheus.Gatherer) ([]metricDesc, error) {
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+	descs := make([]metricDesc, 0, len(mfs))
+	for _, mf := range mfs {
+		desc := metricDesc{
+			Name:   mf.GetName(),
+			Type:   strings.ToLower(mf.GetType().String()),
+			Help:   mf.GetHelp(),
+			Labels: []string{},
+		}
+		if len(mf.Metric) > 0 {
+			for _, lp := range mf.Metric[0].Label {
+				desc.Labels = append(desc.Labels, lp.GetName())
+			}
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+// mergeDescs merges the metric families of b into a, overriding families of the same name, sorted
+// by name.
+func mergeDescs(a, b []metricDesc) []metricDesc {
+	byName := make(map[string]metricDesc, len(a)+len(b))
+	for _, d := range a {
+		byName[d.Name] = d
+	}
+	for _, d := range b {
+		if prev, ok := byName[d.Name]; ok && d.Source == "" {
+			d.Source = prev.Source
+		}
+		byName[d.Name] = d
+	}
+	descs := make([]metricDesc, 0, len(byName))
+	for _, d := range byName {
+		descs = append(descs, d)
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Name < descs[j].Name
+	})
+	return descs
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"runtime/pprof"
+	"time"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	expfmt "github.com/prometheus/common/expfmt"
+)
+
+// diagnostics gathers the state of the exporter into a bundle to attach to bug reports.
+type diagnostics struct {
+	gatherer prometheus.Gatherer
+	targets  []collector.TargetReporter
+	config   map[string]string
+}
+
+// write writes a gzipped tarball with the current metrics, target states, recorded payloads,
+// effective configuration and goroutine dump to a temporary file, returning its path.
+func (d diagnostics) write() (string, error) {
+	f, err := os.CreateTemp("", name+"-diagnostics-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, body []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(body)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(body)
+		return err
+	}
+
+	var metrics bytes.Buffer
+	mfs, err := d.gatherer.Gather()
+	if err != nil {
+		// Gather returns whatever it could collect along with the error.
+		metrics.WriteString("# gather error: " + err.Error() + "\n")
+	}
+	enc := expfmt.NewEncoder(&metrics, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return "", err
+		}
+	}
+	if err := add("metrics.txt", metrics.Bytes()); err != nil {
+		return "", err
+	}
+
+	targets := make([]collector.Target, 0, len(d.targets))
+	for _, t := range d.targets {
+		targets = append(targets, t.Target())
+		if p, ok := t.Payload(); ok {
+			if err := add("payloads/"+p.ScrapePool+".json", p.Body); err != nil {
+				return "", err
+			}
+		}
+	}
+	for name, v := range map[string]interface{}{
+		"targets.json": targets,
+		"config.json":  d.config,
+	} {
+		body, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err := add(name, body); err != nil {
+			return "", err
+		}
+	}
+
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return "", err
+	}
+	if err := add("goroutines.txt", goroutines.Bytes()); err != nil {
+		return "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyDiagnostics relays SIGUSR1, which requests a diagnostics bundle, to ch.
+func notifyDiagnostics(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// notifyDiagnostics is a no-op on Windows, which has no SIGUSR1.
+func notifyDiagnostics(ch chan<- os.Signal) {}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+	exporter "github.com/scraton/typesense_exporter/exporter"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	model "github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// targetNamePattern restricts the names of dynamic targets to what is safe in URLs and labels.
+var targetNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// dynamicTarget is a Typesense cluster registered at runtime through the targets API and scraped
+// with ?target=<name>.
+type dynamicTarget struct {
+	Name   string            `json:"name"`
+	URL    string            `json:"url"`
+	APIKey string            `json:"apiKey,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// dynamicTargetStatus describes a dynamic target in API responses, leaving out its API key.
+type dynamicTargetStatus struct {
+	Name    string             `json:"name"`
+	URL     string             `json:"url"`
+	Labels  map[string]string  `json:"labels,omitempty"`
+	Targets []collector.Target `json:"targets"`
+}
+
+type dynamicEntry struct {
+	target   dynamicTarget
+	exporter *exporter.Exporter
+}
+
+// dynamicTargets holds the targets registered at runtime, each with its own exporter sharing the
+// options of the configured one apart from its credentials.
+type dynamicTargets struct {
+	opts []exporter.Option
+	// stateFile, if set, keeps the registered targets across restarts.
+	stateFile string
+	// onRemove, if set, is called with the name of each target which is removed or replaced.
+	onRemove func(name string)
+
+	mtx     sync.RWMutex
+	entries map[string]*dynamicEntry
+	// saveMtx serializes writes of the state file.
+	saveMtx sync.Mutex
+}
+
+// dynamicTargetsState is the content of the state file.
+type dynamicTargetsState struct {
+	Targets []dynamicTarget `json:"targets"`
+}
+
+func newDynamicTargets(opts []exporter.Option) *dynamicTargets {
+	return &dynamicTargets{
+		opts:    opts,
+		entries: make(map[string]*dynamicEntry),
+	}
+}
+
+// add registers t, replacing a target of the same name, and reports whether it replaced one.
+// Targets need their own API key, as the configured credentials aren't sent to hosts registered
+// at runtime.
+func (d *dynamicTargets) add(t dynamicTarget) (bool, error) {
+	if !targetNamePattern.MatchString(t.Name) {
+		return false, fmt.Errorf("invalid target name %q", t.Name)
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return false, fmt.Errorf("invalid target URL %q", t.URL)
+	}
+	if t.APIKey == "" {
+		return false, fmt.Errorf("target %q needs an API key", t.Name)
+	}
+	for label := range t.Labels {
+		if !model.LabelName(label).IsValid() || strings.HasPrefix(label, "__") || label == "cluster" || label == "target" {
+			return false, fmt.Errorf("invalid label name %q", label)
+		}
+	}
+
+	opts := append(append([]exporter.Option(nil), d.opts...),
+		exporter.WithURL(t.URL),
+		exporter.WithAPIKey(t.APIKey),
+		exporter.WithRegisterer(prometheus.NewRegistry()),
+	)
+	e, err := exporter.New(opts...)
+	if err != nil {
+		return false, err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	old, replaced := d.entries[t.Name]
+	if replaced {
+		old.exporter.Close()
+		if d.onRemove != nil {
+			d.onRemove(t.Name)
+		}
+	}
+	d.entries[t.Name] = &dynamicEntry{target: t, exporter: e}
+	return replaced, nil
+}
+
+// remove unregisters the named target and reports whether it existed.
+func (d *dynamicTargets) remove(name string) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	entry, ok := d.entries[name]
+	if !ok {
+		return false
+	}
+	// The target's series are gone from the next scrape, so Prometheus marks them stale.
+	entry.exporter.Close()
+	delete(d.entries, name)
+	if d.onRemove != nil {
+		d.onRemove(name)
+	}
+	return true
+}
+
+// get returns the exporter and labels of the named target.
+func (d *dynamicTargets) get(name string) (*exporter.Exporter, prometheus.Labels, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	entry, ok := d.entries[name]
+	if !ok {
+		return nil, nil, false
+	}
+	return entry.exporter, prometheus.Labels(entry.target.Labels), true
+}
+
+// list returns the registered targets ordered by name.
+func (d *dynamicTargets) list() []*dynamicEntry {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	entries := make([]*dynamicEntry, 0, len(d.entries))
+	for _, entry := range d.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].target.Name < entries[j].target.Name })
+	return entries
+}
+
+// load registers the targets of the state file, if it exists.
+func (d *dynamicTargets) load() error {
+	if d.stateFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(d.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state dynamicTargetsState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", d.stateFile, err)
+	}
+	for _, t := range state.Targets {
+		if _, err := d.add(t); err != nil {
+			return fmt.Errorf("invalid target %q in %s: %s", t.Name, d.stateFile, err)
+		}
+	}
+	return nil
+}
+
+// save writes the registered targets, including their API keys, to the state file. The file is
+// replaced atomically, so a crash doesn't leave a truncated file behind.
+func (d *dynamicTargets) save() error {
+	if d.stateFile == "" {
+		return nil
+	}
+	d.saveMtx.Lock()
+	defer d.saveMtx.Unlock()
+
+	var state dynamicTargetsState
+	for _, entry := range d.list() {
+		state.Targets = append(state.Targets, entry.target)
+	}
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.stateFile), filepath.Base(d.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.stateFile)
+}
+
+// status describes the registered targets, leaving out their API keys.
+func (d *dynamicTargets) status() []dynamicTargetStatus {
+	entries := d.list()
+	status := make([]dynamicTargetStatus, 0, len(entries))
+	for _, entry := range entries {
+		s := dynamicTargetStatus{
+			Name:    entry.target.Name,
+			URL:     entry.target.URL,
+			Labels:  entry.target.Labels,
+			Targets: []collector.Target{},
+		}
+		for _, t := range entry.exporter.Targets() {
+			s.Targets = append(s.Targets, t.Target())
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// serveTargetAPI adds a target from the JSON body of a POST, or removes the target named by the
+// name query parameter of a DELETE.
+func serveTargetAPI(w http.ResponseWriter, r *http.Request, dynamic *dynamicTargets, logger *log.Logger) {
+	if r.Method == http.MethodDelete {
+		name := r.URL.Query().Get("name")
+		if !dynamic.remove(name) {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
+		logger.WithField("name", name).Infoln("removed target")
+		if err := dynamic.save(); err != nil {
+			logger.WithError(err).Errorln("failed to save targets")
+			http.Error(w, "target removed but not saved: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var t dynamicTarget
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		http.Error(w, "invalid target: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	replaced, err := dynamic.add(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.WithFields(log.Fields{"name": t.Name, "url": t.URL}).Infoln("added target")
+	if err := dynamic.save(); err != nil {
+		logger.WithError(err).Errorln("failed to save targets")
+		http.Error(w, "target added but not saved: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(dynamicTargetStatus{Name: t.Name, URL: t.URL, Labels: t.Labels, Targets: []collector.Target{}}); err != nil {
+		logger.WithError(err).Errorln("failed encoding target")
+	}
+}
+
+// httpSDTarget is a target group in the format of Prometheus' HTTP service discovery.
+type httpSDTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// httpSDTargets lists the dynamic targets for Prometheus' HTTP service discovery, each scraped
+// from the exporter at host with ?target=<name>.
+func httpSDTargets(dynamic *dynamicTargets, host string) []httpSDTarget {
+	entries := dynamic.list()
+	groups := make([]httpSDTarget, 0, len(entries))
+	for _, entry := range entries {
+		// The target's labels are attached by the exporter, so they aren't repeated here.
+		labels := map[string]string{
+			"__param_target":   entry.target.Name,
+			"typesense_target": entry.target.Name,
+		}
+		groups = append(groups, httpSDTarget{Targets: []string{host}, Labels: labels})
+	}
+	return groups
+}
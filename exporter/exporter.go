@@ -0,0 +1,277 @@
+// Package exporter allows embedding the Typesense collectors into the metrics endpoint of another program.
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	version "github.com/prometheus/common/version"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ClusterMetricsCollector scrapes /metrics.json.
+	ClusterMetricsCollector = "cluster_metrics"
+	// APIStatsCollector scrapes /stats.json.
+	APIStatsCollector = "api_stats"
+	// ServerInfoCollector scrapes /debug.
+	ServerInfoCollector = "server_info"
+	// CollectionsCollector scrapes /collections.
+	CollectionsCollector = "collections"
+)
+
+// Policies for sending the API key along when Typesense redirects a request.
+const (
+	// RedirectAPIKeySameHost sends the API key to redirect targets with the same host name, unless
+	// the redirect downgrades from HTTPS to HTTP.
+	RedirectAPIKeySameHost = "same-host"
+	// RedirectAPIKeyAlways sends the API key to any redirect target.
+	RedirectAPIKeyAlways = "always"
+	// RedirectAPIKeyNever doesn't send the API key to redirect targets.
+	RedirectAPIKeyNever = "never"
+)
+
+// Exporter exposes metrics about a single Typesense node.
+type Exporter struct {
+	url                    *url.URL
+	apiKey                 string
+	client                 *http.Client
+	transport              http.RoundTripper
+	middleware             []Middleware
+	timeout                time.Duration
+	logger                 *log.Logger
+	registerer             prometheus.Registerer
+	collectors             []string
+	nativeHistograms       bool
+	legacyNames            bool
+	compatNames            bool
+	latencyUnit            string
+	maxResponseSize        int64
+	strictDecoding         bool
+	retries                int
+	userAgent              string
+	followRedirects        bool
+	redirectAPIKey         string
+	collectionsPageSize    int
+	apiStatsSampleInterval time.Duration
+	apiStatsCollection     bool
+	derivedRatios          bool
+	workers                int
+	scrapeLimiter          *collector.ScrapeLimiter
+	scrapeTimeout          time.Duration
+	failureBackoffMax      time.Duration
+	active                 func() bool
+
+	upstreamMetrics    *collector.UpstreamMetrics
+	typesenseCollector *collector.TypesenseCollector
+	targets            []collector.TargetReporter
+}
+
+type transportWithAPIKey struct {
+	underlyingTransport http.RoundTripper
+	apiKey              string
+	redirectPolicy      string
+}
+
+func (t *transportWithAPIKey) RoundTrip(req *http.Request) (*http.Response, error) {
+	if sendAPIKey(req, t.redirectPolicy) {
+		req.Header.Set("X-Typesense-API-Key", t.apiKey)
+	} else {
+		req.Header.Del("X-Typesense-API-Key")
+	}
+	return t.underlyingTransport.RoundTrip(req)
+}
+
+// sendAPIKey reports whether req may carry the API key under policy. Requests which aren't
+// following a redirect always do.
+func sendAPIKey(req *http.Request, policy string) bool {
+	if req.Response == nil {
+		return true
+	}
+	switch policy {
+	case RedirectAPIKeyAlways:
+		return true
+	case RedirectAPIKeyNever:
+		return false
+	}
+	orig := req
+	for orig.Response != nil && orig.Response.Request != nil {
+		orig = orig.Response.Request
+	}
+	if orig.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return false
+	}
+	return strings.EqualFold(orig.URL.Hostname(), req.URL.Hostname())
+}
+
+type transportWithUserAgent struct {
+	underlyingTransport http.RoundTripper
+	userAgent           string
+}
+
+func (t *transportWithUserAgent) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.underlyingTransport.RoundTrip(req)
+}
+
+// defaultUserAgent identifies requests as coming from this version of the exporter.
+func defaultUserAgent() string {
+	v := version.Version
+	if v == "" {
+		v = "dev"
+	}
+	return "typesense_exporter/" + v
+}
+
+// New creates a new Exporter and registers its collectors.
+func New(opts ...Option) (*Exporter, error) {
+	e := &Exporter{
+		url:        &url.URL{Scheme: "http", Host: "localhost:8108"},
+		timeout:    5 * time.Second,
+		logger:     log.StandardLogger(),
+		registerer: prometheus.DefaultRegisterer,
+		collectors: collector.DefaultCollectors(),
+		userAgent:  defaultUserAgent(),
+
+		followRedirects: true,
+		redirectAPIKey:  RedirectAPIKeySameHost,
+	}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+
+	upstreamMetrics := collector.NewUpstreamMetrics(e.nativeHistograms)
+	typesenseCollector, err := collector.NewTypesenseCollector(collector.Config{
+		Logger:                  e.logger,
+		Client:                  e.httpClient(),
+		URL:                     e.url,
+		UpstreamMetrics:         upstreamMetrics,
+		LegacyNames:             e.legacyNames,
+		CompatNames:             e.compatNames,
+		LatencyUnit:             e.latencyUnit,
+		MaxResponseSize:         e.maxResponseSize,
+		StrictDecoding:          e.strictDecoding,
+		Retries:                 e.retries,
+		CollectionsPageSize:     e.collectionsPageSize,
+		APIStatsSampleInterval:  e.apiStatsSampleInterval,
+		APIStatsCollectionLabel: e.apiStatsCollection,
+		DerivedRatios:           e.derivedRatios,
+		Workers:                 e.workers,
+		ScrapeLimiter:           e.scrapeLimiter,
+		ScrapeTimeout:           e.scrapeTimeout,
+		FailureBackoffMax:       e.failureBackoffMax,
+		Active:                  e.active,
+	}, e.collectors...)
+	if err != nil {
+		return nil, err
+	}
+
+	e.upstreamMetrics = upstreamMetrics
+	e.typesenseCollector = typesenseCollector
+
+	for _, name := range e.collectors {
+		if t, ok := typesenseCollector.Collectors[name].(collector.TargetReporter); ok {
+			e.targets = append(e.targets, t)
+		}
+	}
+
+	for _, c := range []prometheus.Collector{upstreamMetrics, typesenseCollector} {
+		if err := e.registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// Collector returns a collector running only the named collectors, e.g. to expose them on a separate
+// path. They share their state with the collectors registered by New.
+func (e *Exporter) Collector(names ...string) (*collector.TypesenseCollector, error) {
+	return e.typesenseCollector.Filter(names...)
+}
+
+// SelfTest runs every enabled collector once against Typesense and reports which succeeded.
+func (e *Exporter) SelfTest(ctx context.Context) []collector.SelfTestResult {
+	return e.typesenseCollector.SelfTest(ctx)
+}
+
+// Client returns a client fetching decoded responses from Typesense through the same transport,
+// authentication and retries as the collectors.
+func (e *Exporter) Client() *collector.Client {
+	return e.typesenseCollector.Client()
+}
+
+// Close stops the background work of the collectors, e.g. sampling API stats, once the exporter
+// isn't used anymore.
+func (e *Exporter) Close() {
+	e.typesenseCollector.Close()
+}
+
+// UpstreamMetrics returns the exporter's telemetry about requests to Typesense, registered by New
+// alongside the collectors.
+func (e *Exporter) UpstreamMetrics() prometheus.Collector {
+	return e.upstreamMetrics
+}
+
+// Targets returns the Typesense endpoints scraped by the enabled collectors.
+func (e *Exporter) Targets() []collector.TargetReporter {
+	return e.targets
+}
+
+// httpClient returns the client used to talk to Typesense, authenticating requests with the API key
+// and passing them through the configured middleware.
+func (e *Exporter) httpClient() *http.Client {
+	client := http.Client{
+		Timeout: e.timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+		},
+	}
+	if e.client != nil {
+		client = *e.client
+	}
+	if e.transport != nil {
+		client.Transport = e.transport
+	}
+	if !e.followRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	transport = &transportWithLogging{
+		logger:              e.logger,
+		underlyingTransport: transport,
+	}
+	if e.userAgent != "" {
+		transport = &transportWithUserAgent{
+			userAgent:           e.userAgent,
+			underlyingTransport: transport,
+		}
+	}
+	if e.apiKey != "" {
+		transport = &transportWithAPIKey{
+			apiKey:              e.apiKey,
+			redirectPolicy:      e.redirectAPIKey,
+			underlyingTransport: transport,
+		}
+	}
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		transport = e.middleware[i](transport)
+	}
+	client.Transport = transport
+
+	return &client
+}
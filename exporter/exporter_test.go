@@ -0,0 +1,174 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	typesensetest "github.com/scraton/typesense_exporter/typesensetest"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestExporter creates an exporter scraping s with the named collectors, registered with a
+// fresh registry.
+func newTestExporter(t *testing.T, s *typesensetest.Server, opts ...Option) (*Exporter, *prometheus.Registry) {
+	reg := prometheus.NewRegistry()
+	opts = append([]Option{
+		WithURL(s.URL),
+		WithAPIKey("key"),
+		WithRegisterer(reg),
+		WithCollectors("api_stats", "cluster_metrics", "collections", "server_info"),
+	}, opts...)
+	e, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e, reg
+}
+
+// gather returns the metrics of g by family name.
+func gather(t *testing.T, g prometheus.Gatherer) map[string][]*dto.Metric {
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	families := make(map[string][]*dto.Metric, len(mfs))
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf.GetMetric()
+	}
+	return families
+}
+
+// value returns the value of the series among metrics carrying labels, which may be a subset of
+// its labels.
+func value(metrics []*dto.Metric, labels map[string]string) (float64, bool) {
+	for _, m := range metrics {
+		matched := 0
+		for _, lp := range m.GetLabel() {
+			if v, ok := labels[lp.GetName()]; ok && v == lp.GetValue() {
+				matched++
+			}
+		}
+		if matched != len(labels) {
+			continue
+		}
+		switch {
+		case m.Gauge != nil:
+			return m.GetGauge().GetValue(), true
+		case m.Counter != nil:
+			return m.GetCounter().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func expectValue(t *testing.T, families map[string][]*dto.Metric, name string, labels map[string]string, want float64) {
+	t.Helper()
+	got, ok := value(families[name], labels)
+	if !ok {
+		t.Errorf("no series %s%v", name, labels)
+		return
+	}
+	if got != want {
+		t.Errorf("%s%v = %v, want %v", name, labels, got, want)
+	}
+}
+
+func TestExporterGather(t *testing.T) {
+	s := typesensetest.NewServer("key")
+	defer s.Close()
+	s.SetResponse("/collections", http.StatusOK, `[{"name": "products", "num_documents": 42, "created_at": 1650000000, "num_memory_shards": 4, "fields": [{"name": "title", "type": "string", "facet": true}]}]`)
+
+	_, reg := newTestExporter(t, s)
+	families := gather(t, reg)
+
+	for _, name := range []string{"typesense_api_stats_up", "typesense_cluster_metrics_up", "typesense_collections_up", "typesense_server_info_up"} {
+		expectValue(t, families, name, nil, 1)
+	}
+	expectValue(t, families, "typesense_api_stats_latency_seconds", map[string]string{"method": "GET", "endpoint": "/collections/products/documents/search"}, 0.0025)
+	expectValue(t, families, "typesense_api_stats_total_requests_per_second", nil, 1.5)
+	expectValue(t, families, "typesense_cluster_metrics_memory_fragmentation_ratio", nil, 0.22)
+	expectValue(t, families, "typesense_cluster_metrics_system_disk_total_bytes", nil, 102888095744)
+	expectValue(t, families, "typesense_cluster_metrics_system_disk_used_bytes", nil, 4177268736)
+	expectValue(t, families, "typesense_collection_documents", map[string]string{"collection": "products"}, 42)
+	expectValue(t, families, "typesense_collection_facet_fields", map[string]string{"collection": "products"}, 1)
+	expectValue(t, families, "typesense_server_info", map[string]string{"version": "0.23.1"}, 1)
+	expectValue(t, families, "typesense_server_state", nil, 1)
+	expectValue(t, families, "typesense_exporter_scrape_success", map[string]string{"collector": "collections"}, 1)
+}
+
+func TestExporterWrongAPIKey(t *testing.T) {
+	s := typesensetest.NewServer("other")
+	defer s.Close()
+
+	_, reg := newTestExporter(t, s)
+	families := gather(t, reg)
+	for _, name := range []string{"typesense_api_stats_up", "typesense_cluster_metrics_up", "typesense_collections_up"} {
+		expectValue(t, families, name, nil, 0)
+	}
+	// The exporter's own metrics are collected alongside the collectors, so they only show the
+	// failed requests in the next gather.
+	expectValue(t, gather(t, reg), "typesense_exporter_auth_valid", map[string]string{"target": s.URL}, 0)
+}
+
+func TestExporterRetryAfter(t *testing.T) {
+	s := typesensetest.NewServer("key")
+	defer s.Close()
+
+	_, reg := newTestExporter(t, s, WithCollectors("api_stats"))
+	gather(t, reg)
+
+	// A 429 is answered with the last response, and no further requests are made for the
+	// Retry-After window.
+	s.SetResponseHeader("/stats.json", http.StatusTooManyRequests, http.Header{"Retry-After": []string{"60"}}, `{"message": "Rate limit exceeded"}`)
+	for i := 0; i < 3; i++ {
+		families := gather(t, reg)
+		expectValue(t, families, "typesense_api_stats_up", nil, 1)
+		expectValue(t, families, "typesense_api_stats_total_requests_per_second", nil, 1.5)
+	}
+	if n := s.Requests("/stats.json"); n != 2 {
+		t.Errorf("got %d requests to /stats.json, want 2", n)
+	}
+	// The 429 and the three scrapes held back since, including the one of this gather.
+	expectValue(t, gather(t, reg), "typesense_exporter_upstream_throttled_total", map[string]string{"endpoint": "/stats.json"}, 4)
+}
+
+func TestExporterCollectionsPaging(t *testing.T) {
+	var collections []string
+	for i := 0; i < 5; i++ {
+		collections = append(collections, fmt.Sprintf(`{"name": "c%d", "num_documents": %d}`, i, i))
+	}
+	body := "[" + strings.Join(collections, ",") + "]"
+
+	tests := []struct {
+		name     string
+		paginate bool
+		requests int
+	}{
+		{name: "paginated", paginate: true, requests: 3},
+		{name: "without pagination", paginate: false, requests: 1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := typesensetest.NewUnstartedServer("key")
+			s.Paginate = tt.paginate
+			s.SetResponse("/collections", http.StatusOK, body)
+			s.Start()
+			defer s.Close()
+
+			_, reg := newTestExporter(t, s, WithCollectors("collections"), WithCollectionsPageSize(2))
+			families := gather(t, reg)
+			for i := 0; i < 5; i++ {
+				expectValue(t, families, "typesense_collection_documents", map[string]string{"collection": fmt.Sprintf("c%d", i)}, float64(i))
+			}
+			if n := s.Requests("/collections"); n != tt.requests {
+				t.Errorf("got %d requests to /collections, want %d", n, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// transportWithLogging logs the method, URL, status, timing and body size of every request to
+// Typesense at trace level, including retries and redirects. Headers aren't logged, so the API key
+// never ends up in the logs.
+type transportWithLogging struct {
+	underlyingTransport http.RoundTripper
+	logger              *log.Logger
+}
+
+func (t *transportWithLogging) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.logger.IsLevelEnabled(log.TraceLevel) {
+		return t.underlyingTransport.RoundTrip(req)
+	}
+
+	fields := log.Fields{
+		"method": req.Method,
+		"url":    req.URL.Redacted(),
+	}
+	if req.Response != nil {
+		fields["redirected_from"] = req.Response.Request.URL.Redacted()
+	}
+	start := time.Now()
+	res, err := t.underlyingTransport.RoundTrip(req)
+	fields["headers_duration"] = time.Since(start)
+	if err != nil {
+		t.logger.WithFields(fields).WithError(err).Traceln("request to Typesense failed")
+		return nil, err
+	}
+	fields["status"] = res.StatusCode
+	fields["proto"] = res.Proto
+	res.Body = &loggingBody{ReadCloser: res.Body, logger: t.logger, fields: fields, start: start}
+	return res, nil
+}
+
+// loggingBody logs the request once its response body is closed, with the size read.
+type loggingBody struct {
+	io.ReadCloser
+	logger *log.Logger
+	fields log.Fields
+	start  time.Time
+
+	n    int64
+	once sync.Once
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.fields["duration"] = time.Since(b.start)
+		b.fields["body_bytes"] = b.n
+		b.logger.WithFields(b.fields).Traceln("request to Typesense")
+	})
+	return err
+}
@@ -0,0 +1,305 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Option configures an Exporter.
+type Option func(*Exporter) error
+
+// Middleware wraps the transport used for requests to Typesense, e.g. to add authentication, tracing
+// or retries.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// WithURL sets the HTTP API address of the Typesense node, defaults to http://localhost:8108.
+func WithURL(rawURL string) Option {
+	return func(e *Exporter) error {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		e.url = u
+		return nil
+	}
+}
+
+// WithAPIKey sets the API key used to authenticate against Typesense. Requests are sent without an
+// API key when it is empty, leaving authentication to middleware.
+func WithAPIKey(apiKey string) Option {
+	return func(e *Exporter) error {
+		e.apiKey = apiKey
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used for requests to Typesense. The API key header is added on top
+// of the client's transport, and the timeout set by WithTimeout is ignored.
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Exporter) error {
+		e.client = client
+		return nil
+	}
+}
+
+// WithTransport sets the underlying transport for requests to Typesense, overriding the transport
+// of a client set by WithHTTPClient.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(e *Exporter) error {
+		e.transport = transport
+		return nil
+	}
+}
+
+// WithMiddleware wraps the transport used for requests to Typesense. The first middleware is the
+// outermost and sees requests before the API key header is added.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(e *Exporter) error {
+		e.middleware = append(e.middleware, middleware...)
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout for requests to Typesense, defaults to 5s.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *Exporter) error {
+		e.timeout = timeout
+		return nil
+	}
+}
+
+// WithLogger sets the logger, defaults to the logrus standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(e *Exporter) error {
+		e.logger = logger
+		return nil
+	}
+}
+
+// WithRegisterer sets where collectors are registered, defaults to prometheus.DefaultRegisterer.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(e *Exporter) error {
+		e.registerer = registerer
+		return nil
+	}
+}
+
+// WithCollectors sets the enabled collectors, defaults to the collectors registered as enabled by
+// default.
+func WithCollectors(names ...string) Option {
+	return func(e *Exporter) error {
+		e.collectors = names
+		return nil
+	}
+}
+
+// WithNativeHistograms additionally exposes exporter latencies as native histograms.
+func WithNativeHistograms(enabled bool) Option {
+	return func(e *Exporter) error {
+		e.nativeHistograms = enabled
+		return nil
+	}
+}
+
+// WithLegacyNames additionally exposes exporter metrics under their names from before they were
+// renamed.
+func WithLegacyNames(enabled bool) Option {
+	return func(e *Exporter) error {
+		e.legacyNames = enabled
+		return nil
+	}
+}
+
+// WithCompatNames additionally exposes the raw fields of /stats.json and /metrics.json under the
+// typesense_<field> names of the community typesense-prometheus-exporter.
+func WithCompatNames(enabled bool) Option {
+	return func(e *Exporter) error {
+		e.compatNames = enabled
+		return nil
+	}
+}
+
+// WithMaxResponseSize limits the size of response bodies read from Typesense, defaults to 0 for no
+// limit.
+func WithMaxResponseSize(bytes int64) Option {
+	return func(e *Exporter) error {
+		e.maxResponseSize = bytes
+		return nil
+	}
+}
+
+// WithAPIStatsSampleInterval makes the api_stats collector sample /stats.json at interval between
+// scrapes, exposing the min, max and avg of each stat since the last scrape. Defaults to 0, which
+// disables sampling.
+func WithAPIStatsSampleInterval(interval time.Duration) Option {
+	return func(e *Exporter) error {
+		if interval < 0 {
+			return fmt.Errorf("invalid API stats sample interval %s", interval)
+		}
+		e.apiStatsSampleInterval = interval
+		return nil
+	}
+}
+
+// WithDerivedRatios makes the cluster_metrics collector additionally expose disk and memory usage
+// ratios computed by the exporter, for alerting tools which can't divide two series. Defaults to
+// false.
+func WithDerivedRatios(enabled bool) Option {
+	return func(e *Exporter) error {
+		e.derivedRatios = enabled
+		return nil
+	}
+}
+
+// WithAPIStatsCollectionLabel makes the api_stats collector label per-endpoint stats of
+// /collections/<name>/... endpoints with collection="<name>" and endpoint="/collections/:collection/...",
+// so they can be aggregated per collection. Defaults to false, keeping the name in the endpoint.
+func WithAPIStatsCollectionLabel(enabled bool) Option {
+	return func(e *Exporter) error {
+		e.apiStatsCollection = enabled
+		return nil
+	}
+}
+
+// WithRetries retries requests to Typesense failing with a transport error other than a timeout or
+// a 502 or 504 response up to n times within the scrape, with a backoff starting at 100ms. Defaults
+// to 0.
+func WithRetries(n int) Option {
+	return func(e *Exporter) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of retries %d", n)
+		}
+		e.retries = n
+		return nil
+	}
+}
+
+// WithStrictDecoding fails scrapes whose responses contain fields the exporter doesn't know,
+// counting them in typesense_exporter_upstream_unknown_fields_total. Defaults to false.
+func WithStrictDecoding(strict bool) Option {
+	return func(e *Exporter) error {
+		e.strictDecoding = strict
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent to Typesense, defaults to typesense_exporter/<version>.
+// An empty user agent leaves the header to the underlying transport.
+func WithUserAgent(userAgent string) Option {
+	return func(e *Exporter) error {
+		e.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithFollowRedirects sets whether redirects from Typesense are followed, defaults to true. Without,
+// redirects fail the scrape with their status code.
+func WithFollowRedirects(enabled bool) Option {
+	return func(e *Exporter) error {
+		e.followRedirects = enabled
+		return nil
+	}
+}
+
+// WithRedirectAPIKey sets whether the API key is sent along when following a redirect, one of
+// RedirectAPIKeySameHost, the default, RedirectAPIKeyAlways or RedirectAPIKeyNever.
+func WithRedirectAPIKey(policy string) Option {
+	return func(e *Exporter) error {
+		switch policy {
+		case RedirectAPIKeySameHost, RedirectAPIKeyAlways, RedirectAPIKeyNever:
+		default:
+			return fmt.Errorf("invalid redirect API key policy %q", policy)
+		}
+		e.redirectAPIKey = policy
+		return nil
+	}
+}
+
+// WithLatencyUnit sets the unit the latencies of /stats.json are exposed in, one of
+// collector.LatencyUnitSeconds, the default, collector.LatencyUnitMilliseconds or
+// collector.LatencyUnitBoth.
+func WithLatencyUnit(unit string) Option {
+	return func(e *Exporter) error {
+		switch unit {
+		case collector.LatencyUnitSeconds, collector.LatencyUnitMilliseconds, collector.LatencyUnitBoth:
+		default:
+			return fmt.Errorf("invalid latency unit %q", unit)
+		}
+		e.latencyUnit = unit
+		return nil
+	}
+}
+
+// WithCollectionsPageSize pages through collections with the limit and offset parameters of newer
+// Typesense releases, defaults to 0 to list all collections in a single request.
+func WithCollectionsPageSize(size int) Option {
+	return func(e *Exporter) error {
+		if size < 0 {
+			return fmt.Errorf("invalid collections page size %d", size)
+		}
+		e.collectionsPageSize = size
+		return nil
+	}
+}
+
+// WithWorkers limits how many collectors scrape the node at once, defaults to 0 to run all
+// collectors concurrently.
+func WithWorkers(n int) Option {
+	return func(e *Exporter) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of workers %d", n)
+		}
+		e.workers = n
+		return nil
+	}
+}
+
+// WithScrapeLimiter shares limiter between several exporters, capping how many of their collectors
+// run at once overall.
+func WithScrapeLimiter(limiter *collector.ScrapeLimiter) Option {
+	return func(e *Exporter) error {
+		e.scrapeLimiter = limiter
+		return nil
+	}
+}
+
+// WithScrapeTimeout bounds each scrape of the node, including time spent waiting for workers or the
+// scrape limiter, defaults to 0 for no limit besides the deadline of the scrape itself.
+func WithScrapeTimeout(timeout time.Duration) Option {
+	return func(e *Exporter) error {
+		if timeout < 0 {
+			return fmt.Errorf("invalid scrape timeout %s", timeout)
+		}
+		e.scrapeTimeout = timeout
+		return nil
+	}
+}
+
+// WithActive makes background work such as API stats sampling pause while active returns false,
+// e.g. while another replica holds the leader lease. Defaults to always active.
+func WithActive(active func() bool) Option {
+	return func(e *Exporter) error {
+		e.active = active
+		return nil
+	}
+}
+
+// WithFailureBackoff skips scrapes of a collector after its scrapes failed, for 1s doubling up to
+// max, until one succeeds again. Each collector backs off separately, so one failing endpoint
+// doesn't hold back the others. Defaults to 0, which always scrapes.
+func WithFailureBackoff(max time.Duration) Option {
+	return func(e *Exporter) error {
+		if max < 0 {
+			return fmt.Errorf("invalid failure backoff %s", max)
+		}
+		e.failureBackoffMax = max
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+)
+
+// healthStatus is the JSON body of /healthz?format=json.
+type healthStatus struct {
+	Status  string         `json:"status"`
+	Targets []healthTarget `json:"targets"`
+}
+
+// healthTarget is the state of the collectors scraping a Typesense node.
+type healthTarget struct {
+	Cluster    string            `json:"cluster"`
+	Collectors []healthCollector `json:"collectors"`
+}
+
+type healthCollector struct {
+	Collector           string    `json:"collector"`
+	Health              string    `json:"health"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastScrape          time.Time `json:"lastScrape"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+}
+
+// newHealthStatus describes the state of targets grouped by node, with status "ok" or, if
+// healthy is false, "unhealthy".
+func newHealthStatus(targets []collector.TargetReporter, healthy bool) healthStatus {
+	status := healthStatus{Status: "ok", Targets: []healthTarget{}}
+	if !healthy {
+		status.Status = "unhealthy"
+	}
+
+	index := make(map[string]int)
+	for _, t := range targets {
+		target := t.Target()
+		cluster := target.Labels["cluster"]
+		i, ok := index[cluster]
+		if !ok {
+			i = len(status.Targets)
+			index[cluster] = i
+			status.Targets = append(status.Targets, healthTarget{Cluster: cluster})
+		}
+		status.Targets[i].Collectors = append(status.Targets[i].Collectors, healthCollector{
+			Collector:           target.ScrapePool,
+			Health:              target.Health,
+			LastError:           target.LastError,
+			LastScrape:          target.LastScrape,
+			LastSuccess:         target.LastSuccess,
+			ConsecutiveFailures: target.ConsecutiveFailures,
+		})
+	}
+	for _, t := range status.Targets {
+		collectors := t.Collectors
+		sort.Slice(collectors, func(i, j int) bool { return collectors[i].Collector < collectors[j].Collector })
+	}
+	return status
+}
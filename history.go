@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// history keeps the metrics of the last collection cycles of each target in memory, so recent
+// values can be looked at through /api/history without Prometheus.
+type history struct {
+	size int
+
+	mtx     sync.Mutex
+	targets map[string]*historyRing
+}
+
+// historyRing holds the last cycles of a target, overwriting the oldest once full.
+type historyRing struct {
+	cycles []historyCycle
+	next   int
+}
+
+type historyCycle struct {
+	time     time.Time
+	families []*dto.MetricFamily
+}
+
+// historySeries is a series in the result of /api/history, in the layout of a Prometheus range
+// query result.
+type historySeries struct {
+	Target string            `json:"target"`
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+func newHistory(size int) *history {
+	if size <= 0 {
+		return nil
+	}
+	return &history{size: size, targets: make(map[string]*historyRing)}
+}
+
+// gatherer returns g, recording every gather as a cycle of target.
+func (h *history) gatherer(target string, g prometheus.Gatherer) prometheus.Gatherer {
+	if h == nil {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		h.record(target, mfs)
+		return mfs, err
+	})
+}
+
+// forget drops the recorded cycles of target, once it is no longer scraped.
+func (h *history) forget(target string) {
+	if h == nil {
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.targets, target)
+}
+
+func (h *history) record(target string, mfs []*dto.MetricFamily) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	ring, ok := h.targets[target]
+	if !ok {
+		ring = &historyRing{}
+		h.targets[target] = ring
+	}
+	cycle := historyCycle{time: time.Now(), families: mfs}
+	if len(ring.cycles) < h.size {
+		ring.cycles = append(ring.cycles, cycle)
+		return
+	}
+	ring.cycles[ring.next] = cycle
+	ring.next = (ring.next + 1) % h.size
+}
+
+// query returns the recorded values of metric, of all targets or only of target if set, oldest
+// first. Histograms and summaries are queried by their _sum and _count series.
+func (h *history) query(metric, target string) []historySeries {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	names := make([]string, 0, len(h.targets))
+	for name := range h.targets {
+		if target == "" || name == target {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := []historySeries{}
+	for _, name := range names {
+		ring := h.targets[name]
+		index := make(map[string]int)
+		for i := range ring.cycles {
+			cycle := ring.cycles[(ring.next+i)%len(ring.cycles)]
+			ts := float64(cycle.time.UnixNano()) / 1e9
+			for _, mf := range cycle.families {
+				for _, m := range mf.GetMetric() {
+					v, ok := historyValue(mf, m, metric)
+					if !ok {
+						continue
+					}
+					labels := map[string]string{"__name__": metric}
+					for _, lp := range m.GetLabel() {
+						labels[lp.GetName()] = lp.GetValue()
+					}
+					key := seriesKey(labels)
+					j, ok := index[key]
+					if !ok {
+						j = len(result)
+						index[key] = j
+						result = append(result, historySeries{Target: name, Metric: labels})
+					}
+					result[j].Values = append(result[j].Values, [2]interface{}{ts, strconv.FormatFloat(v, 'f', -1, 64)})
+				}
+			}
+		}
+	}
+	return result
+}
+
+// historyValue returns the value of the series metric in m of family mf, if m has one.
+func historyValue(mf *dto.MetricFamily, m *dto.Metric, metric string) (float64, bool) {
+	name := mf.GetName()
+	switch mf.GetType() {
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue(), metric == name
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue(), metric == name
+	case dto.MetricType_UNTYPED:
+		return m.GetUntyped().GetValue(), metric == name
+	case dto.MetricType_HISTOGRAM:
+		switch metric {
+		case name + "_sum":
+			return m.GetHistogram().GetSampleSum(), true
+		case name + "_count":
+			return float64(m.GetHistogram().GetSampleCount()), true
+		}
+	case dto.MetricType_SUMMARY:
+		switch metric {
+		case name + "_sum":
+			return m.GetSummary().GetSampleSum(), true
+		case name + "_count":
+			return float64(m.GetSummary().GetSampleCount()), true
+		}
+	}
+	return 0, false
+}
+
+// seriesKey identifies a series by its sorted label pairs.
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := ""
+	for _, name := range names {
+		key += name + "\xff" + labels[name] + "\xff"
+	}
+	return key
+}
+
+// serveHistory answers /api/history?metric=<name>, optionally limited to &target=<target>.
+func serveHistory(w http.ResponseWriter, r *http.Request, h *history, logger *log.Logger) {
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		http.Error(w, "missing metric parameter", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"resultType": "matrix",
+			"result":     h.query(metric, r.URL.Query().Get("target")),
+		},
+	})
+	if err != nil {
+		logger.WithError(err).Errorln("failed encoding history")
+	}
+}
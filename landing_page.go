@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// landingLink is a link listed on the landing page.
+type landingLink struct {
+	Address     string
+	Text        string
+	Description string
+}
+
+// landingPage is the page served at /, laid out like the exporter-toolkit landing page of the
+// official exporters. The toolkit's own implementation requires a newer Go release than this
+// module supports.
+type landingPage struct {
+	Name        string
+	Description string
+	Version     string
+	Links       []landingLink
+
+	page []byte
+}
+
+var landingPageTemplate = template.Must(template.New("landing-page").Parse(`<!doctype html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>{{.Name}}</title>
+    <style>
+      body {
+        font-family: -apple-system, BlinkMacSystemFont, Segoe UI, Roboto, Helvetica Neue, Arial, sans-serif;
+        margin: 0;
+      }
+      nav {
+        background-color: #e6522c;
+        color: #fff;
+        font-size: 2rem;
+        padding: 1rem;
+      }
+      .main {
+        padding: 1rem;
+      }
+    </style>
+  </head>
+  <body>
+    <nav>{{.Name}}</nav>
+    <div class="main">
+      {{if .Description}}<h3>{{.Description}}</h3>{{end}}
+      {{if .Version}}<div>Version: {{.Version}}</div>{{end}}
+      <div><ul>
+        {{range .Links}}<li><a href="{{.Address}}">{{.Text}}</a>{{if .Description}}: {{.Description}}{{end}}</li>
+        {{end}}
+      </ul></div>
+    </div>
+  </body>
+</html>
+`))
+
+// render renders the page once, so it can be served without executing the template per request.
+func (p *landingPage) render() error {
+	var buf bytes.Buffer
+	if err := landingPageTemplate.Execute(&buf, p); err != nil {
+		return err
+	}
+	p.page = buf.Bytes()
+	return nil
+}
+
+// ServeHTTP implements http.Handler, serving the page at / and 404 for any other path.
+func (p *landingPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(p.page)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTimeLayout is the RFC 3339 layout with microseconds used by Kubernetes for Lease times.
+const microTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// lease is the subset of a coordination.k8s.io/v1 Lease used for leader election.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+// errLeaseNotFound is returned by getLease when the Lease doesn't exist yet.
+var errLeaseNotFound = fmt.Errorf("lease not found")
+
+// leaderElector holds a Kubernetes Lease while it is the leader among exporter replicas, following
+// the same protocol as client-go's leader election so it can share a Lease with it.
+type leaderElector struct {
+	logger   *log.Logger
+	client   *http.Client
+	apiURL   string
+	token    string
+	identity string
+
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	retryPeriod   time.Duration
+
+	mtx       sync.RWMutex
+	leading   bool
+	renewedAt time.Time
+
+	leader prometheus.Gauge
+}
+
+// newLeaderElector creates a leaderElector using the in-cluster service account of the pod.
+func newLeaderElector(logger *log.Logger, namespace, leaseName, identity string, leaseDuration, retryPeriod time.Duration) (*leaderElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %s", err)
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in service account CA")
+	}
+
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account namespace: %s", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	return &leaderElector{
+		logger: logger,
+		client: &http.Client{
+			Timeout: retryPeriod,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+		apiURL:   "https://" + net.JoinHostPort(host, port),
+		token:    strings.TrimSpace(string(token)),
+		identity: identity,
+
+		namespace:     namespace,
+		name:          leaseName,
+		leaseDuration: leaseDuration,
+		retryPeriod:   retryPeriod,
+
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(name, "", "leader"),
+			Help: "Whether this exporter replica holds the leader election Lease and scrapes Typesense",
+		}),
+	}, nil
+}
+
+// Describe implements the prometheus.Collector interface.
+func (l *leaderElector) Describe(ch chan<- *prometheus.Desc) {
+	l.leader.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (l *leaderElector) Collect(ch chan<- prometheus.Metric) {
+	l.leader.Collect(ch)
+}
+
+// Leading reports whether the replica currently holds the Lease.
+func (l *leaderElector) Leading() bool {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	return l.leading && time.Since(l.renewedAt) < l.leaseDuration
+}
+
+// Run tries to acquire and renew the Lease every retry period until ctx is done, then releases it.
+func (l *leaderElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		l.tryAcquireOrRenew(ctx)
+
+		select {
+		case <-ctx.Done():
+			l.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *leaderElector) setLeading(leading bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if leading {
+		l.renewedAt = time.Now()
+	}
+	if leading != l.leading {
+		l.logger.WithFields(log.Fields{
+			"identity": l.identity,
+			"lease":    l.namespace + "/" + l.name,
+		}).Infof("leader election: leading=%t", leading)
+	}
+	l.leading = leading
+	if leading {
+		l.leader.Set(1)
+	} else {
+		l.leader.Set(0)
+	}
+}
+
+func (l *leaderElector) tryAcquireOrRenew(ctx context.Context) {
+	now := time.Now()
+	nowStr := now.UTC().Format(microTimeLayout)
+	durationSeconds := int32(l.leaseDuration / time.Second)
+
+	current, err := l.getLease(ctx)
+	if err == errLeaseNotFound {
+		transitions := int32(0)
+		err = l.writeLease(ctx, http.MethodPost, &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: l.name, Namespace: l.namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       &l.identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &nowStr,
+				RenewTime:            &nowStr,
+				LeaseTransitions:     &transitions,
+			},
+		})
+		if err != nil {
+			l.logger.WithError(err).Warnln("leader election: failed to create lease")
+			l.setLeading(false)
+			return
+		}
+		l.setLeading(true)
+		return
+	}
+	if err != nil {
+		l.logger.WithError(err).Warnln("leader election: failed to get lease")
+		// Keep leading until the lease would have expired, as the other replicas would.
+		if !l.Leading() {
+			l.setLeading(false)
+		}
+		return
+	}
+
+	holder := ""
+	if current.Spec.HolderIdentity != nil {
+		holder = *current.Spec.HolderIdentity
+	}
+	if holder != "" && holder != l.identity && !leaseExpired(current.Spec, now) {
+		l.setLeading(false)
+		return
+	}
+
+	if holder != l.identity {
+		transitions := int32(0)
+		if current.Spec.LeaseTransitions != nil {
+			transitions = *current.Spec.LeaseTransitions
+		}
+		transitions++
+		current.Spec.HolderIdentity = &l.identity
+		current.Spec.AcquireTime = &nowStr
+		current.Spec.LeaseTransitions = &transitions
+	}
+	current.Spec.LeaseDurationSeconds = &durationSeconds
+	current.Spec.RenewTime = &nowStr
+
+	if err := l.writeLease(ctx, http.MethodPut, current); err != nil {
+		l.logger.WithError(err).Warnln("leader election: failed to update lease")
+		if !l.Leading() {
+			l.setLeading(false)
+		}
+		return
+	}
+	l.setLeading(true)
+}
+
+// release gives up the Lease on shutdown so another replica can take over without waiting for it
+// to expire.
+func (l *leaderElector) release() {
+	if !l.Leading() {
+		return
+	}
+	l.setLeading(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.retryPeriod)
+	defer cancel()
+
+	current, err := l.getLease(ctx)
+	if err != nil || current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != l.identity {
+		return
+	}
+	empty := ""
+	current.Spec.HolderIdentity = &empty
+	if err := l.writeLease(ctx, http.MethodPut, current); err != nil {
+		l.logger.WithError(err).Warnln("leader election: failed to release lease")
+	}
+}
+
+func leaseExpired(spec leaseSpec, now time.Time) bool {
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	renewed, err := time.Parse(time.RFC3339Nano, *spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (l *leaderElector) leaseURL(withName bool) string {
+	u := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.apiURL, l.namespace)
+	if withName {
+		u += "/" + l.name
+	}
+	return u
+}
+
+func (l *leaderElector) getLease(ctx context.Context) (*lease, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.leaseURL(true), nil)
+	if err != nil {
+		return nil, err
+	}
+	var current lease
+	if err := l.do(req, &current); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// writeLease creates the Lease with POST or updates it with PUT. Updates carry the resource version
+// they were based on, so concurrent updates by other replicas fail with a conflict.
+func (l *leaderElector) writeLease(ctx context.Context, method string, le *lease) error {
+	body, err := json.Marshal(le)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, l.leaseURL(method == http.MethodPut), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return l.do(req, nil)
+}
+
+func (l *leaderElector) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+l.token)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return errLeaseNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s %s failed with code %d: %s", req.Method, req.URL.Path, res.StatusCode, bytes.TrimSpace(msg))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// leaderRegisterer registers collectors so they are only collected while elector is leading.
+// Standby replicas still expose their descriptors and the exporter's own metrics.
+type leaderRegisterer struct {
+	prometheus.Registerer
+	elector *leaderElector
+}
+
+func (r leaderRegisterer) Register(c prometheus.Collector) error {
+	return r.Registerer.Register(leaderCollector{Collector: c, elector: r.elector})
+}
+
+func (r leaderRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+type leaderCollector struct {
+	prometheus.Collector
+	elector *leaderElector
+}
+
+func (c leaderCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.elector.Leading() {
+		c.Collector.Collect(ch)
+	}
+}
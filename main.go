@@ -2,51 +2,453 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	collector "github.com/scraton/typesense_exporter/collector"
+	exporter "github.com/scraton/typesense_exporter/exporter"
 
 	flag "github.com/namsral/flag"
 	log "github.com/sirupsen/logrus"
 
 	prometheus "github.com/prometheus/client_golang/prometheus"
+	collectors "github.com/prometheus/client_golang/prometheus/collectors"
 	promhttp "github.com/prometheus/client_golang/prometheus/promhttp"
+	model "github.com/prometheus/common/model"
 	version "github.com/prometheus/common/version"
 )
 
 const name = "typesense_exporter"
 
-type transportWithAPIKey struct {
-	underlyingTransport http.RoundTripper
-	apiKey              string
+// sanitizedConfig returns the effective value of every flag and of the settings of every instance
+// of the config file, keyed by instances[<index>].<key>, with secrets and URL credentials masked.
+func sanitizedConfig(fs *flag.FlagSet, instances []instanceConfig) map[string]string {
+	config := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value != "" && isSecretFlag(f.Name) {
+			value = "********"
+		}
+		config[f.Name] = maskURLCredentials(value)
+	})
+	for i, inst := range instances {
+		prefix := fmt.Sprintf("instances[%d].", i)
+		config[prefix+"telemetry-path"] = inst.TelemetryPath
+		config[prefix+"typesense-url"] = maskURLCredentials(inst.URL)
+		if inst.APIKey != "" {
+			config[prefix+"typesense-api-key"] = "********"
+		}
+		if inst.Timeout > 0 {
+			config[prefix+"typesense-timeout"] = inst.Timeout.String()
+		}
+		if len(inst.Collectors) > 0 {
+			config[prefix+"collectors"] = strings.Join(inst.Collectors, ",")
+		}
+		for name, on := range inst.CollectorToggles {
+			config[prefix+"collector-"+strings.Replace(name, "_", "-", -1)] = strconv.FormatBool(on)
+		}
+	}
+	return config
+}
+
+// maskURLCredentials masks the user info of value if it is a URL, so user:pass@host doesn't leak.
+func maskURLCredentials(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	u.User = nil
+	return strings.Replace(u.String(), "://", "://********@", 1)
+}
+
+func isSecretFlag(name string) bool {
+	for _, suffix := range []string{"key", "password", "secret", "token"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamUnreachable reports whether the last failures scrapes of every target failed.
+func upstreamUnreachable(targets []collector.TargetReporter, failures int) bool {
+	if len(targets) == 0 {
+		return false
+	}
+	for _, t := range targets {
+		if t.Target().ConsecutiveFailures < failures {
+			return false
+		}
+	}
+	return true
+}
+
+// warmUp runs every collector of exporters once, logging those which failed, and reports whether
+// all succeeded.
+func warmUp(ctx context.Context, exporters []*exporter.Exporter, logger *log.Logger) bool {
+	ok := true
+	for _, e := range exporters {
+		for _, res := range e.SelfTest(ctx) {
+			if res.Success {
+				continue
+			}
+			ok = false
+			logger.WithFields(log.Fields{
+				"target":    res.Target,
+				"collector": res.Collector,
+				"error":     res.Error,
+			}).Warnln("startup scrape failed")
+		}
+	}
+	return ok
+}
+
+// scrapeDeadline returns when a scrape has to be answered, from the timeout Prometheus sends along
+// with its scrape requests and the telemetry timeout, or the zero time if neither is set. offset is
+// subtracted to leave time for serializing the response, unless the timeout is shorter than that.
+func scrapeDeadline(r *http.Request, telemetryTimeout, offset time.Duration) time.Time {
+	timeout := telemetryTimeout
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+			scrapeTimeout := time.Duration(seconds * float64(time.Second))
+			if timeout == 0 || scrapeTimeout < timeout {
+				timeout = scrapeTimeout
+			}
+		}
+	}
+	if timeout == 0 {
+		return time.Time{}
+	}
+	if timeout > offset {
+		timeout -= offset
+	}
+	return time.Now().Add(timeout)
+}
+
+// selectCollectors returns the enabled collectors selected by the collect[] and exclude[] query
+// parameters, or nil when neither is given. Without collect[], all enabled collectors are selected
+// before applying exclude[].
+func selectCollectors(enabled, collect, exclude []string) ([]string, error) {
+	if len(collect) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	isEnabled := make(map[string]bool, len(enabled))
+	for _, name := range enabled {
+		isEnabled[name] = true
+	}
+	for _, name := range append(append([]string(nil), collect...), exclude...) {
+		if !isEnabled[name] {
+			return nil, fmt.Errorf("collector %q not enabled", name)
+		}
+	}
+
+	if len(collect) == 0 {
+		collect = enabled
+	}
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+	names := make([]string, 0, len(collect))
+	for _, name := range collect {
+		if !excluded[name] {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// kubernetesLabels returns constant labels identifying the pod, from environment variables set with
+// the Kubernetes downward API. Variables which are unset are skipped.
+func kubernetesLabels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	for label, env := range map[string]string{
+		"pod":       "POD_NAME",
+		"namespace": "POD_NAMESPACE",
+		"node":      "NODE_NAME",
+	} {
+		if value := os.Getenv(env); value != "" {
+			labels[label] = value
+		}
+	}
+	return labels
+}
+
+// envLabelFlags collects label=ENV_VAR mappings, repeated or comma-separated.
+type envLabelFlags map[string]string
+
+func (l envLabelFlags) String() string {
+	return labelFlags(l).String()
+}
+
+func (l envLabelFlags) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		if err := labelFlags(l).Set(pair); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (t *transportWithAPIKey) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Add("X-Typesense-API-Key", t.apiKey)
-	return t.underlyingTransport.RoundTrip(req)
+// collectorIntervalFlags collects collector=duration background scrape intervals, repeated or
+// comma-separated.
+type collectorIntervalFlags map[string]time.Duration
+
+func (f collectorIntervalFlags) String() string {
+	pairs := make([]string, 0, len(f))
+	for name, interval := range f {
+		pairs = append(pairs, name+"="+interval.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f collectorIntervalFlags) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid interval %q, must be collector=duration", pair)
+		}
+		interval, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return err
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %q, must be positive", pair)
+		}
+		f[kv[0]] = interval
+	}
+	return nil
+}
+
+// envLabels returns constant labels with the values of the environment variables they are mapped
+// to. Labels whose variable is unset are skipped with a warning.
+func envLabels(mapping envLabelFlags, logger *log.Logger) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for label, env := range mapping {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			logger.WithFields(log.Fields{"label": label, "env": env}).Warnln("environment variable for label not set, skipping")
+			continue
+		}
+		labels[label] = value
+	}
+	return labels
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "generate-rules" {
+		if err := generateRules(os.Stdout, os.Args[2:]); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			log.WithError(err).Fatal("unable to generate rules")
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Stdout, os.Args[2:]); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			log.WithError(err).Fatal("benchmark failed")
+		}
+		return
+	}
+
 	var (
 		listenAddressFlag    string
+		webTLSCertFileFlag   string
+		webTLSKeyFileFlag    string
+		webTLSReloadFlag     string
+		webAuthTokenFlag     string
+		webAuthTokenFileFlag string
+		webOIDCIssuerFlag    string
+		webOIDCAudienceFlag  string
+		webAllowedCIDRsFlag  string
 		telemetryPathFlag    string
+		telemetryTimeoutFlag string
+		timeoutOffsetFlag    string
 		typesenseURLFlag     string
 		typesenseTimeoutFlag string
 		typesenseAPIKeyFlag  string
-		logLevelFlag         string
+
+		typesenseOAuth2TokenURLFlag     string
+		typesenseOAuth2ClientIDFlag     string
+		typesenseOAuth2ClientSecretFlag string
+		typesenseOAuth2ScopesFlag       string
+		logLevelFlag                    string
+		configFileFlag                  string
+
+		typesenseConnectTimeoutFlag      string
+		typesenseMaxIdleConnsPerHostFlag int
+		typesenseIdleConnTimeoutFlag     string
+		typesenseTLSHandshakeTimeoutFlag string
+		typesenseDNSServerFlag           string
+		typesenseDNSCacheTTLFlag         string
+		typesenseIPProtocolFlag          string
+		typesenseMaxResponseSizeFlag     int64
+		typesenseStrictDecodingFlag      bool
+		typesenseRetriesFlag             int
+		typesenseUserAgentFlag           string
+		typesenseFollowRedirectsFlag     bool
+		typesenseRedirectAuthFlag        string
+		collectionsPageSizeFlag          int
+		typesenseWorkersFlag             int
+		typesenseMaxConcurrentFlag       int
+		maxUpstreamConcurrencyFlag       int
+		typesenseScrapeTimeoutFlag       string
+		typesenseScrapeIntervalFlag      string
+		typesenseScrapeTimestampsFlag    bool
+		typesenseScrapeJitterFlag        bool
+		typesenseScrapeMaxAgeFlag        string
+		typesenseFailureBackoffFlag      string
+		apiStatsSampleIntervalFlag       string
+		apiStatsCollectionLabelFlag      bool
+		derivedRatiosFlag                bool
+
+		leaderElectionFlag              bool
+		leaderElectionNamespaceFlag     string
+		leaderElectionLeaseNameFlag     string
+		leaderElectionIdentityFlag      string
+		leaderElectionLeaseDurationFlag string
+		leaderElectionRetryPeriodFlag   string
+
+		kubernetesLabelsFlag       bool
+		healthRequireUpstreamFlag  int
+		healthDetailFlag           bool
+		failOnStartupErrorFlag     bool
+		automaxprocsFlag           bool
+		memlimitRatioFlag          float64
+		webEnableDebugPayloadsFlag bool
+		historySizeFlag            int
+		nativeHistogramsFlag       bool
+		legacyNamesFlag            bool
+		compatNamesFlag            bool
+		latencyUnitFlag            string
+		labelFromEnvFlag           = envLabelFlags{}
+		collectorIntervalFlag      = collectorIntervalFlags{}
+
+		telemetryMaxRequestsFlag        int
+		telemetryDisableCompressionFlag bool
+		telemetryCollectorPathsFlag     bool
+		telemetryTargetPatternFlag      string
+		telemetryTargetIdleTimeoutFlag  string
+		webEnableTargetAPIFlag          bool
+		webTargetStateFileFlag          string
+		typesenseDisableCompressionFlag bool
+		typesenseHTTP2Flag              bool
+		disableExporterMetricsFlag      bool
 	)
 
 	fs := flag.NewFlagSetWithEnvPrefix(os.Args[0], "", 0)
+	collectorFlags := make(map[string]*bool)
+	for name, enabled := range collector.Collectors() {
+		collectorFlags[name] = fs.Bool(
+			"collector-"+strings.Replace(name, "_", "-", -1),
+			enabled,
+			"enable the "+name+" collector",
+		)
+	}
+	fs.StringVar(&configFileFlag, "config-file", "", "YAML, TOML or JSON file (by extension) mapping flag names to values, overridden by flags and environment variables")
 	fs.StringVar(&listenAddressFlag, "listen-address", ":9115", "address to listen on for metrics interface")
+	fs.StringVar(&webTLSCertFileFlag, "web-tls-cert-file", "", "certificate file to serve HTTPS with, reloaded when it changes or on SIGHUP")
+	fs.StringVar(&webTLSKeyFileFlag, "web-tls-key-file", "", "private key file for web-tls-cert-file")
+	fs.StringVar(&webTLSReloadFlag, "web-tls-reload-interval", "10s", "how often to check the web TLS certificate and key files for changes")
+	fs.StringVar(&webAuthTokenFlag, "web-auth-token", "", "require this bearer token on every endpoint but /healthz")
+	fs.StringVar(&webAuthTokenFileFlag, "web-auth-token-file", "", "file with bearer tokens to require on every endpoint but /healthz, one per line")
+	fs.StringVar(&webOIDCIssuerFlag, "web-oidc-issuer-url", "", "require a bearer JWT issued by this OIDC issuer on every endpoint but /healthz, unless it is a web-auth-token")
+	fs.StringVar(&webOIDCAudienceFlag, "web-oidc-audience", "", "audience the JWTs from web-oidc-issuer-url must be issued for, required with web-oidc-issuer-url")
+	fs.StringVar(&webAllowedCIDRsFlag, "web-allowed-cidrs", "", "comma-separated CIDRs allowed to reach every endpoint but /healthz, others get 403; empty allows all")
+	fs.BoolVar(&webEnableTargetAPIFlag, "web-enable-target-api", false, "allow adding and removing targets scraped with ?target=<name> through POST and DELETE /api/targets, requires web authentication")
+	fs.StringVar(&webTargetStateFileFlag, "web-target-state-file", "", "file keeping the targets added with web-enable-target-api across restarts, including their API keys")
 	fs.StringVar(&telemetryPathFlag, "telemetry-path", "/metrics", "path under which to expose metrics")
+	fs.IntVar(&telemetryMaxRequestsFlag, "telemetry-max-requests", 0, "maximum number of concurrent scrapes, 0 for no limit")
+	fs.StringVar(&telemetryTimeoutFlag, "telemetry-timeout", "0s", "timeout for serving a scrape, 0 for no timeout")
+	fs.StringVar(&timeoutOffsetFlag, "timeout-offset", "0.5s", "time subtracted from the scrape timeout to leave for serializing the response")
+	fs.BoolVar(&telemetryDisableCompressionFlag, "telemetry-disable-compression", false, "disable compression of scrape responses")
+	fs.BoolVar(&telemetryCollectorPathsFlag, "telemetry-collector-paths", false, "additionally expose each collector under <telemetry-path>/<collector>")
+	fs.StringVar(&telemetryTargetPatternFlag, "telemetry-target-pattern", "", "regular expression matching the host:port or URL a scrape may select with ?target= instead of typesense-url, empty to reject ?target=")
+	fs.StringVar(&telemetryTargetIdleTimeoutFlag, "telemetry-target-idle-timeout", "10m", "how long a ?target= node may go unscraped before the exporter drops its state, 0 to keep it forever")
+	fs.BoolVar(&disableExporterMetricsFlag, "telemetry-disable-exporter-metrics", false, "exclude Go runtime, process and metrics handler metrics")
 	fs.StringVar(&typesenseURLFlag, "typesense-url", "http://localhost:8108", "HTTP API address for Typesense node")
 	fs.StringVar(&typesenseTimeoutFlag, "typesense-timeout", "5s", "timeout for trying to get Typesense metrics")
 	fs.StringVar(&typesenseAPIKeyFlag, "typesense-api-key", "", "API key for typesense")
-	fs.StringVar(&logLevelFlag, "log-level", "info", "sets log level")
+	fs.StringVar(&typesenseOAuth2TokenURLFlag, "typesense-oauth2-token-url", "", "token URL to fetch an OAuth2 bearer token from with the client credentials grant, sent to Typesense alongside the API key")
+	fs.StringVar(&typesenseOAuth2ClientIDFlag, "typesense-oauth2-client-id", "", "OAuth2 client ID for typesense-oauth2-token-url")
+	fs.StringVar(&typesenseOAuth2ClientSecretFlag, "typesense-oauth2-client-secret", "", "OAuth2 client secret for typesense-oauth2-token-url")
+	fs.StringVar(&typesenseOAuth2ScopesFlag, "typesense-oauth2-scopes", "", "comma-separated OAuth2 scopes to request")
+	fs.StringVar(&typesenseConnectTimeoutFlag, "typesense-connect-timeout", "5s", "timeout for establishing connections to Typesense")
+	fs.BoolVar(&typesenseDisableCompressionFlag, "typesense-disable-compression", false, "do not request gzip-compressed responses from Typesense")
+	fs.BoolVar(&typesenseHTTP2Flag, "typesense-http2", false, "force HTTP/2 to Typesense, using h2c for http:// URLs")
+	fs.IntVar(&typesenseMaxIdleConnsPerHostFlag, "typesense-max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "maximum idle connections kept open to Typesense")
+	fs.StringVar(&typesenseIdleConnTimeoutFlag, "typesense-idle-conn-timeout", "90s", "how long idle connections to Typesense are kept open")
+	fs.StringVar(&typesenseTLSHandshakeTimeoutFlag, "typesense-tls-handshake-timeout", "10s", "timeout for TLS handshakes with Typesense")
+	fs.StringVar(&typesenseDNSServerFlag, "typesense-dns-server", "", "DNS server (host:port) for resolving Typesense, defaults to the system resolver")
+	fs.StringVar(&typesenseDNSCacheTTLFlag, "typesense-dns-cache-ttl", "0s", "how long to cache resolved Typesense addresses, 0 to disable")
+	fs.StringVar(&typesenseIPProtocolFlag, "typesense-ip-protocol", "any", "address family used to connect to Typesense: ip4, ip6 or any")
+	fs.Int64Var(&typesenseMaxResponseSizeFlag, "typesense-max-response-size", 0, "maximum size in bytes of responses read from Typesense, 0 for no limit")
+	fs.IntVar(&typesenseRetriesFlag, "typesense-retries", 0, "how often to retry requests to Typesense failing with a connection error or a 502 or 504 response")
+	fs.BoolVar(&typesenseStrictDecodingFlag, "typesense-strict-decoding", false, "fail scrapes of responses with fields unknown to the exporter, counting them")
+	fs.StringVar(&typesenseUserAgentFlag, "typesense-user-agent", "", "User-Agent sent to Typesense, defaults to typesense_exporter/<version>")
+	fs.BoolVar(&typesenseFollowRedirectsFlag, "typesense-follow-redirects", true, "follow redirects from Typesense, otherwise they fail the scrape")
+	fs.StringVar(&typesenseRedirectAuthFlag, "typesense-redirect-auth", exporter.RedirectAPIKeySameHost, "when to send the API key along when following a redirect: same-host, always or never")
+	fs.IntVar(&typesenseWorkersFlag, "typesense-workers", 0, "number of collectors scraping each Typesense node at once, 0 to run all at once")
+	fs.IntVar(&typesenseMaxConcurrentFlag, "typesense-max-concurrent-scrapes", 0, "number of collectors scraping at once across all targets, 0 for no limit")
+	fs.IntVar(&maxUpstreamConcurrencyFlag, "max-upstream-concurrency", 0, "number of requests to Typesense in flight at once across all collectors and targets, 0 for no limit")
+	fs.StringVar(&typesenseScrapeTimeoutFlag, "typesense-scrape-timeout", "0s", "time limit for scraping a Typesense node including waiting for workers, 0 for no limit besides the scrape timeout")
+	fs.StringVar(&typesenseScrapeIntervalFlag, "typesense-scrape-interval", "0s", "scrape Typesense in the background at this interval and serve the last results, 0 to scrape on every request")
+	fs.BoolVar(&typesenseScrapeTimestampsFlag, "typesense-scrape-timestamps", false, "attach the time of the background scrape to the metrics served from it")
+	fs.Var(collectorIntervalFlag, "typesense-scrape-collector-interval", "scrape a collector in the background at its own collector=duration interval instead of typesense-scrape-interval, may be repeated or comma-separated")
+	fs.BoolVar(&typesenseScrapeJitterFlag, "typesense-scrape-jitter", false, "spread background scrapes over the interval at a fixed offset per target and collector instead of scraping all at once")
+	fs.StringVar(&typesenseFailureBackoffFlag, "typesense-failure-backoff-max", "0s", "skip scrapes of a collector of a node whose scrapes failed, for 1s doubling up to this duration until one succeeds, 0 to always scrape")
+	fs.StringVar(&typesenseScrapeMaxAgeFlag, "typesense-scrape-max-age", "0s", "drop background scrape results once they are this old and report their collector down, serving the last successful results of a failing collector until then, 0 to serve failures right away")
+	fs.IntVar(&collectionsPageSizeFlag, "collections-page-size", 0, "number of collections listed per request by the collections collector, 0 to list all at once")
+	fs.StringVar(&apiStatsSampleIntervalFlag, "api-stats-sample-interval", "0s", "how often to sample /stats.json between scrapes to expose the min, max and avg since the last scrape, 0 to disable")
+	fs.BoolVar(&apiStatsCollectionLabelFlag, "api-stats-collection-label", false, "label per-endpoint API stats with the collection, replacing its name in the endpoint with :collection")
+	fs.BoolVar(&derivedRatiosFlag, "derived-ratios", false, "additionally expose disk and memory usage ratios computed from /metrics.json")
+	hostname, _ := os.Hostname()
+	fs.BoolVar(&leaderElectionFlag, "leader-election", false, "only scrape Typesense while holding a Kubernetes Lease, so one of several replicas is active")
+	fs.StringVar(&leaderElectionNamespaceFlag, "leader-election-namespace", "", "namespace of the leader election Lease, defaults to the pod's namespace")
+	fs.StringVar(&leaderElectionLeaseNameFlag, "leader-election-lease-name", "typesense-exporter", "name of the leader election Lease")
+	fs.StringVar(&leaderElectionIdentityFlag, "leader-election-identity", hostname, "identity of this replica in the leader election Lease")
+	fs.StringVar(&leaderElectionLeaseDurationFlag, "leader-election-lease-duration", "15s", "how long standby replicas wait before taking over an unrenewed Lease")
+	fs.StringVar(&leaderElectionRetryPeriodFlag, "leader-election-retry-period", "2s", "how often to try to acquire or renew the Lease")
+	fs.BoolVar(&kubernetesLabelsFlag, "kubernetes-labels", false, "add pod, namespace and node labels from the POD_NAME, POD_NAMESPACE and NODE_NAME environment variables")
+	fs.Var(labelFromEnvFlag, "label-from-env", "add a constant label=ENV_VAR label with the value of the environment variable to every metric, may be repeated or comma-separated")
+	fs.BoolVar(&automaxprocsFlag, "automaxprocs", true, "set GOMAXPROCS to the container's CPU quota, unless GOMAXPROCS is set")
+	fs.Float64Var(&memlimitRatioFlag, "memlimit-ratio", 0.9, "set the Go memory limit to this ratio of the container's memory limit unless GOMEMLIMIT is set, 0 to disable")
+	fs.IntVar(&healthRequireUpstreamFlag, "health-require-upstream", 0, "fail /healthz once this many consecutive scrapes of every Typesense endpoint failed, 0 to disable")
+	fs.BoolVar(&failOnStartupErrorFlag, "fail-on-startup-error", false, "exit if any collector fails the collection pass run at startup")
+	fs.BoolVar(&healthDetailFlag, "health-detail", false, "serve the status, last error and last success of every collector on /healthz?format=json, which needs no web authentication")
+	fs.StringVar(&logLevelFlag, "log-level", "info", "sets log level: trace, debug, info, warn or error; trace logs every request to Typesense")
+	fs.BoolVar(&nativeHistogramsFlag, "native-histograms", false, "additionally expose exporter latencies as native histograms")
+	fs.BoolVar(&legacyNamesFlag, "legacy-names", false, "additionally expose exporter metrics under their names from before they were renamed")
+	fs.StringVar(&latencyUnitFlag, "latency-unit", collector.LatencyUnitSeconds, "unit to expose the latencies of /stats.json in: seconds, milliseconds as *_latency_ms for dashboards built on Typesense's field names, or both")
+	fs.BoolVar(&compatNamesFlag, "compat-names", false, "additionally expose the fields of /stats.json and /metrics.json under the typesense_<field> names of typesense-prometheus-exporter")
+	fs.BoolVar(&webEnableDebugPayloadsFlag, "web-enable-debug-payloads", false, "expose the last raw payloads fetched from Typesense")
+	fs.IntVar(&historySizeFlag, "history-size", 0, "number of collection cycles of each target kept in memory for /api/history, 0 to disable")
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		if err == flag.ErrHelp {
@@ -55,6 +457,13 @@ func main() {
 
 		log.WithError(err).Fatal("unable to parse arguments")
 	}
+	var instances []instanceConfig
+	if configFileFlag != "" {
+		var err error
+		if instances, err = loadConfigFile(fs, configFileFlag); err != nil {
+			log.WithError(err).Fatal("unable to load config file")
+		}
+	}
 
 	// Initialize logger
 	logLevel, _ := log.ParseLevel(logLevelFlag)
@@ -65,71 +474,765 @@ func main() {
 		Level:     logLevel,
 	}
 
-	typesenseURL, err := url.Parse(typesenseURLFlag)
-	if err != nil {
-		logger.WithError(err).Fatalf("unable to parse typesense url")
+	if automaxprocsFlag {
+		setMaxProcs(logger)
 	}
+	setMemoryLimit(memlimitRatioFlag, logger)
 
 	typesenseTimeout, err := time.ParseDuration(typesenseTimeoutFlag)
 	if err != nil {
 		logger.WithError(err).Fatalf("unable to parse timeout")
 	}
 
+	typesenseConnectTimeout, err := time.ParseDuration(typesenseConnectTimeoutFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse connect timeout")
+	}
+
+	typesenseIdleConnTimeout, err := time.ParseDuration(typesenseIdleConnTimeoutFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse idle connection timeout")
+	}
+
+	typesenseTLSHandshakeTimeout, err := time.ParseDuration(typesenseTLSHandshakeTimeoutFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse TLS handshake timeout")
+	}
+
+	typesenseDNSCacheTTL, err := time.ParseDuration(typesenseDNSCacheTTLFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse DNS cache TTL")
+	}
+
+	switch typesenseIPProtocolFlag {
+	case "ip4", "ip6", "any":
+	default:
+		logger.Fatalf("invalid IP protocol %q, must be ip4, ip6 or any", typesenseIPProtocolFlag)
+	}
+
+	telemetryTimeout, err := time.ParseDuration(telemetryTimeoutFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse telemetry timeout")
+	}
+
+	telemetryTargetIdleTimeout, err := time.ParseDuration(telemetryTargetIdleTimeoutFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse telemetry target idle timeout")
+	}
+
+	timeoutOffset, err := time.ParseDuration(timeoutOffsetFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse timeout offset")
+	}
+
+	webTLSReloadInterval, err := time.ParseDuration(webTLSReloadFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse web TLS reload interval")
+	}
+
+	if (webTLSCertFileFlag == "") != (webTLSKeyFileFlag == "") {
+		logger.Fatal("web-tls-cert-file and web-tls-key-file must be set together")
+	}
+
+	typesenseScrapeTimeout, err := time.ParseDuration(typesenseScrapeTimeoutFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse scrape timeout")
+	}
+
+	typesenseScrapeInterval, err := time.ParseDuration(typesenseScrapeIntervalFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse scrape interval")
+	}
+
+	typesenseScrapeMaxAge, err := time.ParseDuration(typesenseScrapeMaxAgeFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse scrape max age")
+	}
+
+	typesenseFailureBackoff, err := time.ParseDuration(typesenseFailureBackoffFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse failure backoff")
+	}
+
+	apiStatsSampleInterval, err := time.ParseDuration(apiStatsSampleIntervalFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse API stats sample interval")
+	}
+
 	if typesenseAPIKeyFlag == "" {
 		logger.Fatal("no API key provided")
 	}
 
+	// Without an audience, any token of a shared issuer, e.g. of any Google account, would do.
+	if webOIDCIssuerFlag != "" && webOIDCAudienceFlag == "" {
+		logger.Fatal("web-oidc-issuer-url requires web-oidc-audience")
+	}
+	if webEnableTargetAPIFlag && webAuthTokenFlag == "" && webAuthTokenFileFlag == "" && webOIDCIssuerFlag == "" {
+		logger.Fatal("web-enable-target-api requires web-auth-token, web-auth-token-file or web-oidc-issuer-url")
+	}
+
+	leaderElectionLeaseDuration, err := time.ParseDuration(leaderElectionLeaseDurationFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse leader election lease duration")
+	}
+
+	leaderElectionRetryPeriod, err := time.ParseDuration(leaderElectionRetryPeriodFlag)
+	if err != nil {
+		logger.WithError(err).Fatalf("unable to parse leader election retry period")
+	}
+
+	effectiveConfig := sanitizedConfig(fs, instances)
+
 	logger.WithFields(log.Fields{
 		"listen":  listenAddressFlag,
 		"path":    telemetryPathFlag,
-		"url":     typesenseURL,
+		"url":     typesenseURLFlag,
 		"timeout": typesenseTimeout,
 	}).Debugln("initialized")
 
-	httpTransport := &transportWithAPIKey{
-		apiKey: typesenseAPIKeyFlag,
-		underlyingTransport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-		},
+	constLabels := envLabels(labelFromEnvFlag, logger)
+	if kubernetesLabelsFlag {
+		for label, value := range kubernetesLabels() {
+			constLabels[label] = value
+		}
+	}
+	for label := range constLabels {
+		if !model.LabelName(label).IsValid() || strings.HasPrefix(label, "__") {
+			logger.Fatalf("invalid label name %q", label)
+		}
 	}
-	httpClient := &http.Client{
-		Timeout:   typesenseTimeout,
-		Transport: httpTransport,
+
+	// wrapRegisterer adds the constant labels to metrics registered with r.
+	wrapRegisterer := func(r prometheus.Registerer) prometheus.Registerer {
+		if len(constLabels) > 0 {
+			r = prometheus.WrapRegistererWith(constLabels, r)
+		}
+		return r
 	}
 
-	prometheus.MustRegister(version.NewCollector(name))
-	prometheus.MustRegister(collector.NewClusterMetrics(logger, httpClient, typesenseURL))
-	prometheus.MustRegister(collector.NewAPIStats(logger, httpClient, typesenseURL))
+	registry := prometheus.NewRegistry()
+	registerer := wrapRegisterer(registry)
+	if !disableExporterMetricsFlag {
+		registerer.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+
+	var elector *leaderElector
+	if leaderElectionFlag {
+		elector, err = newLeaderElector(
+			logger,
+			leaderElectionNamespaceFlag,
+			leaderElectionLeaseNameFlag,
+			leaderElectionIdentityFlag,
+			leaderElectionLeaseDuration,
+			leaderElectionRetryPeriod,
+		)
+		if err != nil {
+			logger.WithError(err).Fatal("unable to set up leader election")
+		}
+		registerer.MustRegister(elector)
+	}
+
+	// typesenseRegisterer wraps r for the collectors scraping Typesense, which are only collected
+	// while leading.
+	typesenseRegisterer := func(r prometheus.Registerer) prometheus.Registerer {
+		r = wrapRegisterer(r)
+		if elector != nil {
+			r = leaderRegisterer{Registerer: r, elector: elector}
+		}
+		return r
+	}
+
+	// exporterRegistry holds the collectors scraping Typesense, apart from the exporter's own
+	// metrics, so scrapes can select a subset of the collectors.
+	exporterRegistry := prometheus.NewRegistry()
+
+	var enabledCollectors []string
+	for name, enabled := range collectorFlags {
+		if *enabled {
+			enabledCollectors = append(enabledCollectors, name)
+		}
+	}
+	sort.Strings(enabledCollectors)
+
+	if len(collectorIntervalFlag) > 0 && typesenseScrapeInterval <= 0 {
+		logger.Fatal("typesense-scrape-collector-interval requires typesense-scrape-interval")
+	}
+	for name := range collectorIntervalFlag {
+		if enabled, ok := collectorFlags[name]; !ok || !*enabled {
+			logger.WithField("collector", name).Fatal("scrape interval set for a collector which isn't enabled")
+		}
+	}
+
+	// credentialOpts authenticate against typesense-url and the nodes scraped with ?target=. They
+	// are kept apart from the other options, so config file instances and targets registered at
+	// runtime, which bring their own API keys, never get to see them.
+	credentialOpts := []exporter.Option{exporter.WithAPIKey(typesenseAPIKeyFlag)}
+	if typesenseOAuth2TokenURLFlag != "" {
+		var scopes []string
+		for _, scope := range strings.Split(typesenseOAuth2ScopesFlag, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+		tokenSource := newOAuth2TokenSource(
+			typesenseOAuth2TokenURLFlag,
+			typesenseOAuth2ClientIDFlag,
+			typesenseOAuth2ClientSecretFlag,
+			scopes,
+			typesenseTimeout,
+		)
+		credentialOpts = append(credentialOpts, exporter.WithMiddleware(tokenSource.Middleware()))
+	}
+
+	sharedOpts := []exporter.Option{
+		exporter.WithURL(typesenseURLFlag),
+		exporter.WithTimeout(typesenseTimeout),
+		exporter.WithTransport(newTransport(transportConfig{
+			connectTimeout:      typesenseConnectTimeout,
+			disableCompression:  typesenseDisableCompressionFlag,
+			maxIdleConnsPerHost: typesenseMaxIdleConnsPerHostFlag,
+			idleConnTimeout:     typesenseIdleConnTimeout,
+			tlsHandshakeTimeout: typesenseTLSHandshakeTimeout,
+			dnsServer:           typesenseDNSServerFlag,
+			dnsCacheTTL:         typesenseDNSCacheTTL,
+			ipProtocol:          typesenseIPProtocolFlag,
+			h2c:                 typesenseHTTP2Flag,
+		})),
+		exporter.WithLogger(logger),
+		exporter.WithRegisterer(typesenseRegisterer(exporterRegistry)),
+		exporter.WithCollectors(enabledCollectors...),
+		exporter.WithNativeHistograms(nativeHistogramsFlag),
+		exporter.WithLegacyNames(legacyNamesFlag),
+		exporter.WithCompatNames(compatNamesFlag),
+		exporter.WithLatencyUnit(latencyUnitFlag),
+		exporter.WithMaxResponseSize(typesenseMaxResponseSizeFlag),
+		exporter.WithStrictDecoding(typesenseStrictDecodingFlag),
+		exporter.WithRetries(typesenseRetriesFlag),
+		exporter.WithCollectionsPageSize(collectionsPageSizeFlag),
+		exporter.WithAPIStatsSampleInterval(apiStatsSampleInterval),
+		exporter.WithAPIStatsCollectionLabel(apiStatsCollectionLabelFlag),
+		exporter.WithDerivedRatios(derivedRatiosFlag),
+		exporter.WithWorkers(typesenseWorkersFlag),
+		exporter.WithScrapeLimiter(collector.NewScrapeLimiter(typesenseMaxConcurrentFlag)),
+		exporter.WithScrapeTimeout(typesenseScrapeTimeout),
+		exporter.WithFailureBackoff(typesenseFailureBackoff),
+		exporter.WithFollowRedirects(typesenseFollowRedirectsFlag),
+		exporter.WithRedirectAPIKey(typesenseRedirectAuthFlag),
+	}
+	if maxUpstreamConcurrencyFlag > 0 {
+		limiter := newUpstreamLimiter(maxUpstreamConcurrencyFlag)
+		registerer.MustRegister(limiter)
+		sharedOpts = append(sharedOpts, exporter.WithMiddleware(limiter.Middleware()))
+	}
+	if typesenseUserAgentFlag != "" {
+		sharedOpts = append(sharedOpts, exporter.WithUserAgent(typesenseUserAgentFlag))
+	}
+	if elector != nil {
+		sharedOpts = append(sharedOpts, exporter.WithActive(elector.Leading))
+	}
+
+	if typesenseScrapeInterval > 0 {
+		// The collectors are registered through the scrape cache instead.
+		sharedOpts = append(sharedOpts, exporter.WithRegisterer(prometheus.NewRegistry()))
+	}
+
+	exporterOpts := append(append([]exporter.Option(nil), credentialOpts...), sharedOpts...)
+
+	typesenseExporter, err := exporter.New(exporterOpts...)
+	if err != nil {
+		logger.WithError(err).Fatal("unable to create exporter")
+	}
+
+	hist := newHistory(historySizeFlag)
+
+	var targetExporters *targetExporters
+	if telemetryTargetPatternFlag != "" {
+		scheme := "http"
+		if u, err := url.Parse(typesenseURLFlag); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+		targetExporters, err = newTargetExporters(telemetryTargetPatternFlag, scheme, telemetryTargetIdleTimeout, exporterOpts)
+		if err != nil {
+			logger.WithError(err).Fatal("unable to parse telemetry target pattern")
+		}
+		targetExporters.onEvict = hist.forget
+	}
+
+	var dynamic *dynamicTargets
+	if webEnableTargetAPIFlag {
+		dynamic = newDynamicTargets(sharedOpts)
+		dynamic.stateFile = webTargetStateFileFlag
+		dynamic.onRemove = hist.forget
+		if err := dynamic.load(); err != nil {
+			logger.WithError(err).Fatal("unable to load targets")
+		}
+	}
+
+	targets := typesenseExporter.Targets()
+
+	// instanceExporters scrape the clusters of the instances in the config file, each served on its
+	// own telemetry path.
+	instanceExporters := make([]*exporter.Exporter, len(instances))
+	for i, inst := range instances {
+		if inst.TelemetryPath == telemetryPathFlag {
+			logger.Fatalf("telemetry path %s of instance %d is already used by telemetry-path", inst.TelemetryPath, i+1)
+		}
+		for _, other := range instances[:i] {
+			if other.TelemetryPath == inst.TelemetryPath {
+				logger.Fatalf("telemetry path %s used by several instances", inst.TelemetryPath)
+			}
+		}
+
+		opts := append(append([]exporter.Option(nil), sharedOpts...),
+			exporter.WithURL(inst.URL),
+			exporter.WithAPIKey(inst.APIKey),
+			exporter.WithRegisterer(prometheus.NewRegistry()),
+		)
+		if inst.Timeout > 0 {
+			opts = append(opts, exporter.WithTimeout(inst.Timeout))
+		}
+		if len(inst.Collectors) > 0 || len(inst.CollectorToggles) > 0 {
+			opts = append(opts, exporter.WithCollectors(inst.collectors(enabledCollectors)...))
+		}
+		e, err := exporter.New(opts...)
+		if err != nil {
+			logger.WithError(err).WithField("path", inst.TelemetryPath).Fatal("unable to create exporter instance")
+		}
+		instanceExporters[i] = e
+		targets = append(targets, e.Targets()...)
+	}
+
+	for _, t := range targets {
+		t.RecordPayloads(webEnableDebugPayloadsFlag)
+	}
+
+	// A first collection pass surfaces misconfiguration right away instead of at the first scrape.
+	// Standby replicas leave Typesense to the leader.
+	if elector != nil && !elector.Leading() {
+		logger.Debugln("skipping startup scrape, not leading")
+	} else if !warmUp(context.Background(), append([]*exporter.Exporter{typesenseExporter}, instanceExporters...), logger) && failOnStartupErrorFlag {
+		logger.Fatal("startup scrape failed, exiting as fail-on-startup-error is set")
+	}
+
+	var cache *scrapeCache
+	if typesenseScrapeInterval > 0 {
+		cached := make(map[string]*collector.TypesenseCollector, len(enabledCollectors))
+		for _, name := range enabledCollectors {
+			c, err := typesenseExporter.Collector(name)
+			if err != nil {
+				logger.WithError(err).Fatal("unable to create collector")
+			}
+			cached[name] = c
+		}
+		cache = newScrapeCache(logger, cached, typesenseScrapeInterval)
+		cache.timestamps = typesenseScrapeTimestampsFlag
+		cache.maxAge = typesenseScrapeMaxAge
+		cache.jitter = typesenseScrapeJitterFlag
+		cache.intervals = collectorIntervalFlag
+		if elector != nil {
+			cache.active = elector.Leading
+		}
+		typesenseRegisterer(exporterRegistry).MustRegister(cache, typesenseExporter.UpstreamMetrics())
+	}
+
+	startTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName(name, "", "start_time_seconds"),
+		Help: "Unix timestamp at which the exporter was started",
+	})
+	startTime.SetToCurrentTime()
+
+	// configInfo makes configuration drift between exporter instances visible in Prometheus.
+	configInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName(name, "", "config_info"),
+		Help: "A metric with a constant '1' value labeled by key settings of the exporter",
+		ConstLabels: prometheus.Labels{
+			"collectors":         strings.Join(enabledCollectors, ","),
+			"scrape_interval":    typesenseScrapeInterval.String(),
+			"scrape_timeout":     typesenseScrapeTimeout.String(),
+			"timeout":            typesenseTimeout.String(),
+			"configured_targets": strconv.Itoa(1 + len(instances)),
+		},
+	})
+	configInfo.Set(1)
+
+	registerer.MustRegister(version.NewCollector(name))
+	registerer.MustRegister(startTime, configInfo)
 
 	server := &http.Server{}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
+	diagnosticsSignals := make(chan os.Signal, 1)
+	notifyDiagnostics(diagnosticsSignals)
+	go func() {
+		d := diagnostics{
+			gatherer: prometheus.Gatherers{registry, exporterRegistry},
+			targets:  targets,
+			config:   effectiveConfig,
+		}
+		for range diagnosticsSignals {
+			path, err := d.write()
+			if err != nil {
+				logger.WithError(err).Errorln("failed to write diagnostics")
+				continue
+			}
+			logger.WithField("path", path).Infoln("wrote diagnostics")
+		}
+	}()
+
+	if cache != nil {
+		go cache.Run(ctx)
+	}
+
+	electorDone := make(chan struct{})
+	if elector != nil {
+		go func() {
+			elector.Run(ctx)
+			close(electorDone)
+		}()
+	} else {
+		close(electorDone)
+	}
+
 	mux := http.DefaultServeMux
-	mux.Handle(telemetryPathFlag, promhttp.Handler())
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, err = w.Write([]byte(`<html>
-			<head><title>Typesense Exporter</title></head>
-			<body>
-			<h1>Typesense Exporter</h1>
-			<p><a href="` + telemetryPathFlag + `">Metrics</a></p>
-			</body>
-			</html>`))
+	handlerOpts := promhttp.HandlerOpts{
+		EnableOpenMetrics:   true,
+		MaxRequestsInFlight: telemetryMaxRequestsFlag,
+		Timeout:             telemetryTimeout,
+		DisableCompression:  telemetryDisableCompressionFlag,
+		ErrorLog:            logger,
+	}
+	allMetricsHandler := promhttp.HandlerFor(hist.gatherer(typesenseURLFlag, prometheus.Gatherers{registry, exporterRegistry}), handlerOpts)
+	var metricsHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		names, err := selectCollectors(enabledCollectors, query["collect[]"], query["exclude[]"])
 		if err != nil {
-			logger.WithError(err).Errorln("failed handling writing")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deadline := scrapeDeadline(r, telemetryTimeout, timeoutOffset)
+		target := query.Get("target")
+		// The scrape cache answers right away, so it is served regardless of the deadline.
+		if names == nil && target == "" && (deadline.IsZero() || cache != nil) {
+			allMetricsHandler.ServeHTTP(w, r)
+			return
 		}
+		if names == nil {
+			names = enabledCollectors
+		}
+
+		e := typesenseExporter
+		// Scrapes of another target leave out the exporter's own metrics, which the scrape of
+		// typesense-url already carries.
+		gatherers := prometheus.Gatherers{registry}
+		var targetLabels prometheus.Labels
+		if target != "" {
+			var ok bool
+			if dynamic != nil {
+				e, targetLabels, ok = dynamic.get(target)
+			}
+			if !ok {
+				if targetExporters == nil {
+					http.Error(w, "unknown target, see telemetry-target-pattern and web-enable-target-api", http.StatusBadRequest)
+					return
+				}
+				e, err = targetExporters.get(target)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			gatherers = nil
+		}
+
+		c, err := e.Collector(names...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filteredRegistry := prometheus.NewRegistry()
+		filteredRegisterer := typesenseRegisterer(filteredRegistry)
+		if len(targetLabels) > 0 {
+			filteredRegisterer = prometheus.WrapRegistererWith(targetLabels, filteredRegisterer)
+		}
+		filteredRegisterer.MustRegister(c.WithDeadline(deadline), e.UpstreamMetrics())
+		historyTarget := target
+		if target == "" {
+			historyTarget = typesenseURLFlag
+		}
+		promhttp.HandlerFor(hist.gatherer(historyTarget, append(gatherers, filteredRegistry)), handlerOpts).ServeHTTP(w, r)
 	})
+	if !disableExporterMetricsFlag {
+		metricsHandler = promhttp.InstrumentMetricHandler(registerer, metricsHandler)
+	}
+	mux.Handle(telemetryPathFlag, metricsHandler)
+	if telemetryCollectorPathsFlag {
+		for _, name := range enabledCollectors {
+			c, err := typesenseExporter.Collector(name)
+			if err != nil {
+				logger.WithError(err).Fatal("unable to create collector")
+			}
+			collectorRegistry := prometheus.NewRegistry()
+			typesenseRegisterer(collectorRegistry).MustRegister(c)
+
+			path := strings.TrimSuffix(telemetryPathFlag, "/") + "/" + strings.Replace(name, "_", "-", -1)
+			mux.Handle(path, promhttp.HandlerFor(hist.gatherer(typesenseURLFlag, collectorRegistry), handlerOpts))
+		}
+	}
+	for i, inst := range instances {
+		e := instanceExporters[i]
+		c, err := e.Collector(inst.collectors(enabledCollectors)...)
+		if err != nil {
+			logger.WithError(err).Fatal("unable to create collector")
+		}
+		instanceURL := inst.URL
+		mux.Handle(inst.TelemetryPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			instanceRegistry := prometheus.NewRegistry()
+			deadline := scrapeDeadline(r, telemetryTimeout, timeoutOffset)
+			typesenseRegisterer(instanceRegistry).MustRegister(c.WithDeadline(deadline), e.UpstreamMetrics())
+			promhttp.HandlerFor(hist.gatherer(instanceURL, instanceRegistry), handlerOpts).ServeHTTP(w, r)
+		}))
+	}
+	mux.HandleFunc("/api/targets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost, http.MethodDelete:
+			if dynamic == nil {
+				http.Error(w, "target API not enabled, see web-enable-target-api", http.StatusMethodNotAllowed)
+				return
+			}
+			serveTargetAPI(w, r, dynamic, logger)
+			return
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		activeTargets := make([]collector.Target, 0, len(targets))
+		for _, t := range targets {
+			activeTargets = append(activeTargets, t.Target())
+		}
+		data := map[string]interface{}{
+			"activeTargets": activeTargets,
+		}
+		if dynamic != nil {
+			data["dynamicTargets"] = dynamic.status()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   data,
+		})
+		if err != nil {
+			logger.WithError(err).Errorln("failed encoding targets")
+		}
+	})
+	if dynamic != nil {
+		mux.HandleFunc("/api/sd", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(httpSDTargets(dynamic, r.Host)); err != nil {
+				logger.WithError(err).Errorln("failed encoding service discovery targets")
+			}
+		})
+	}
+	if hist != nil {
+		mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+			serveHistory(w, r, hist, logger)
+		})
+	}
+	mux.HandleFunc("/selftest", func(w http.ResponseWriter, r *http.Request) {
+		// Only the configured clusters are tested, so a request doesn't fan out to every node
+		// scraped with ?target= or registered at runtime.
+		exporters := append([]*exporter.Exporter{typesenseExporter}, instanceExporters...)
+		var results []collector.SelfTestResult
+		for _, e := range exporters {
+			results = append(results, e.SelfTest(r.Context())...)
+		}
+
+		status := "success"
+		for _, res := range results {
+			if !res.Success {
+				status = "failure"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status != "success" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  status,
+			"results": results,
+		})
+		if err != nil {
+			logger.WithError(err).Errorln("failed encoding self-test results")
+		}
+	})
+	allCollectors, err := typesenseExporter.Collector(enabledCollectors...)
+	if err != nil {
+		logger.WithError(err).Fatal("unable to create collector")
+	}
+	dashboard := newDashboard(describe(allCollectors))
+	mux.HandleFunc("/dashboard.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(dashboard); err != nil {
+			logger.WithError(err).Errorln("failed encoding dashboard")
+		}
+	})
+	mux.HandleFunc("/metrics-docs", func(w http.ResponseWriter, r *http.Request) {
+		// The exporter's own metrics are gathered rather than described, which doesn't scrape
+		// Typesense and tells histograms apart from gauges.
+		upstreamRegistry := prometheus.NewRegistry()
+		upstreamRegistry.MustRegister(typesenseExporter.UpstreamMetrics())
+		gathered, err := gatheredDescs(prometheus.Gatherers{registry, upstreamRegistry})
+		if err != nil {
+			logger.WithError(err).Warnln("failed gathering exporter metrics")
+		}
+		descs := mergeDescs(describe(allCollectors, typesenseExporter.UpstreamMetrics()), gathered)
+		if err := serveMetricsDocs(w, r, descs); err != nil {
+			logger.WithError(err).Errorln("failed writing metrics docs")
+		}
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(effectiveConfig); err != nil {
+			logger.WithError(err).Errorln("failed encoding config")
+		}
+	})
+	if webEnableDebugPayloadsFlag {
+		mux.HandleFunc("/debug/payloads", func(w http.ResponseWriter, r *http.Request) {
+			pool := r.URL.Query().Get("scrape_pool")
+			if pool == "" {
+				payloads := make([]collector.Payload, 0, len(targets))
+				for _, t := range targets {
+					if p, ok := t.Payload(); ok {
+						payloads = append(payloads, p)
+					}
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(payloads); err != nil {
+					logger.WithError(err).Errorln("failed encoding payloads")
+				}
+				return
+			}
+
+			for _, t := range targets {
+				if p, ok := t.Payload(); ok && p.ScrapePool == pool {
+					w.Header().Set("Content-Type", "application/json")
+					if _, err := w.Write(p.Body); err != nil {
+						logger.WithError(err).Errorln("failed writing payload")
+					}
+					return
+				}
+			}
+			http.NotFound(w, r)
+		})
+	}
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		healthy := healthRequireUpstreamFlag <= 0 || !upstreamUnreachable(targets, healthRequireUpstreamFlag)
+		if healthDetailFlag && r.URL.Query().Get("format") == "json" {
+			code := http.StatusOK
+			if !healthy {
+				code = http.StatusServiceUnavailable
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			if err := json.NewEncoder(w).Encode(newHealthStatus(targets, healthy)); err != nil {
+				logger.WithError(err).Errorln("failed encoding health")
+			}
+			return
+		}
+		if !healthy {
+			http.Error(w, "Typesense unreachable", http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
 	})
 
+	landing := &landingPage{
+		Name:        name,
+		Description: "Prometheus exporter for Typesense",
+		Version:     version.Info(),
+		Links: []landingLink{
+			{Address: telemetryPathFlag, Text: "Metrics"},
+			{Address: "/metrics-docs", Text: "Metrics documentation", Description: "every metric the exporter can emit"},
+			{Address: "/dashboard.json", Text: "Grafana dashboard"},
+			{Address: "/api/targets", Text: "Targets", Description: "scraped Typesense endpoints and their health"},
+			{Address: "/selftest", Text: "Self-test", Description: "runs every enabled collector and reports failures"},
+			{Address: "/config", Text: "Configuration"},
+			{Address: "/healthz", Text: "Health"},
+		},
+	}
+	if hist != nil {
+		landing.Links = append(landing.Links, landingLink{Address: "/api/history", Text: "History", Description: "recent values of a metric, with ?metric=<name>"})
+	}
+	if webEnableDebugPayloadsFlag {
+		landing.Links = append(landing.Links, landingLink{Address: "/debug/payloads", Text: "Debug payloads", Description: "last raw payloads fetched from Typesense"})
+	}
+	if err := landing.render(); err != nil {
+		logger.WithError(err).Fatal("unable to render landing page")
+	}
+	mux.Handle("/", landing)
+
 	server.Handler = mux
+	if webAuthTokenFlag != "" || webAuthTokenFileFlag != "" || webOIDCIssuerFlag != "" {
+		auth := &webAuth{
+			logger: logger,
+			exempt: map[string]bool{"/healthz": true},
+		}
+		if webAuthTokenFlag != "" {
+			auth.tokens = append(auth.tokens, webAuthTokenFlag)
+		}
+		if webAuthTokenFileFlag != "" {
+			b, err := ioutil.ReadFile(webAuthTokenFileFlag)
+			if err != nil {
+				logger.WithError(err).Fatal("unable to read web auth token file")
+			}
+			for _, line := range strings.Split(string(b), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					auth.tokens = append(auth.tokens, line)
+				}
+			}
+		}
+		if webOIDCIssuerFlag != "" {
+			auth.oidc = newOIDCVerifier(webOIDCIssuerFlag, webOIDCAudienceFlag, typesenseTimeout)
+		}
+		server.Handler = auth.Handler(mux)
+	}
+	if webAllowedCIDRsFlag != "" {
+		allowlist, err := newWebAllowlist(webAllowedCIDRsFlag)
+		if err != nil {
+			logger.WithError(err).Fatal("unable to parse web allowed CIDRs")
+		}
+		allowlist.exempt = map[string]bool{"/healthz": true}
+		server.Handler = allowlist.Handler(server.Handler)
+	}
 	server.Addr = listenAddressFlag
 
+	listen := server.ListenAndServe
+	if webTLSCertFileFlag != "" {
+		certs, err := newCertReloader(logger, webTLSCertFileFlag, webTLSKeyFileFlag)
+		if err != nil {
+			logger.WithError(err).Fatal("unable to load web TLS certificate")
+		}
+		reloadSignals := make(chan os.Signal, 1)
+		signal.Notify(reloadSignals, syscall.SIGHUP)
+		go certs.Run(ctx, webTLSReloadInterval, reloadSignals)
+
+		server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		listen = func() error {
+			return server.ListenAndServeTLS("", "")
+		}
+	}
+
 	logger.WithField("addr", listenAddressFlag).Infof("starting typesense exporter")
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
+		if err := listen(); err != nil {
 			if err == http.ErrServerClosed {
 				return
 			}
@@ -146,4 +1249,21 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.WithError(err).Errorln("failed to shutdown")
 	}
+
+	// Closing the exporters stops their background work, such as API stats sampling.
+	typesenseExporter.Close()
+	for _, e := range instanceExporters {
+		e.Close()
+	}
+	if targetExporters != nil {
+		for _, e := range targetExporters.all() {
+			e.Close()
+		}
+	}
+	if dynamic != nil {
+		for _, entry := range dynamic.list() {
+			entry.exporter.Close()
+		}
+	}
+	<-electorDone
 }
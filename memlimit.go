@@ -0,0 +1,26 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import (
+	"os"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setMemoryLimit sets the Go runtime's soft memory limit to ratio of the container's memory limit,
+// unless GOMEMLIMIT is set in the environment.
+func setMemoryLimit(ratio float64, logger *log.Logger) {
+	if ratio <= 0 || os.Getenv("GOMEMLIMIT") != "" {
+		return
+	}
+	limit, ok := cgroupMemoryLimit()
+	if !ok {
+		return
+	}
+	memLimit := int64(float64(limit) * ratio)
+	debug.SetMemoryLimit(memLimit)
+	logger.WithField("bytes", memLimit).Debugln("set memory limit from cgroup")
+}
@@ -0,0 +1,15 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// setMemoryLimit is a no-op before Go 1.19, which introduced the soft memory limit.
+func setMemoryLimit(ratio float64, logger *log.Logger) {
+	if ratio > 0 {
+		logger.Debugln("memory limit requires Go 1.19, not setting it from cgroup")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var metricsDocsTemplate = template.Must(template.New("metrics-docs").Parse(`<html>
+	<head><title>Typesense Exporter Metrics</title></head>
+	<body>
+	<h1>Typesense Exporter Metrics</h1>
+	<table border="1" cellpadding="4" cellspacing="0">
+	<tr><th>Name</th><th>Type</th><th>Labels</th><th>Help</th><th>Source</th></tr>
+	{{range .}}<tr><td><code>{{.Name}}</code></td><td>{{.Type}}</td><td>{{range $i, $l := .Labels}}{{if $i}}, {{end}}{{$l}}{{end}}</td><td>{{.Help}}</td><td>{{.Source}}</td></tr>
+	{{end}}</table>
+	</body>
+	</html>`))
+
+// serveMetricsDocs writes descs as JSON when requested with ?format=json or an Accept header
+// preferring JSON, and as an HTML table otherwise.
+func serveMetricsDocs(w http.ResponseWriter, r *http.Request, descs []metricDesc) error {
+	if r.URL.Query().Get("format") == "json" || strings.HasPrefix(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(descs)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return metricsDocsTemplate.Execute(w, descs)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	exporter "github.com/scraton/typesense_exporter/exporter"
+)
+
+// oauth2ExpiryDelta is how long before its expiry a token is refreshed, so requests in flight don't
+// carry a token expiring on the way.
+const oauth2ExpiryDelta = 10 * time.Second
+
+// oauth2TokenSource fetches and caches an access token with the OAuth2 client credentials grant.
+type oauth2TokenSource struct {
+	client       *http.Client
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	mtx    sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newOAuth2TokenSource(tokenURL, clientID, clientSecret string, scopes []string, timeout time.Duration) *oauth2TokenSource {
+	return &oauth2TokenSource{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
+		},
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+	}
+}
+
+// Token returns the cached access token, fetching a new one when there is none or it is about to
+// expire.
+func (s *oauth2TokenSource) Token(ctx context.Context) (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Add(oauth2ExpiryDelta).Before(s.expiry)) {
+		return s.token, nil
+	}
+
+	token, expiresIn, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.expiry = time.Time{}
+	if expiresIn > 0 {
+		s.expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return s.token, nil
+}
+
+// invalidate drops the cached token if it is still token, so the next request fetches a new one.
+func (s *oauth2TokenSource) invalidate(token string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+func (s *oauth2TokenSource) fetch(ctx context.Context) (string, int64, error) {
+	form := url.Values{"grant_type": []string{"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch OAuth2 token: %s", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch OAuth2 token: %s", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", 0, fmt.Errorf("failed to fetch OAuth2 token: %s returned code %d: %s", s.tokenURL, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", 0, fmt.Errorf("failed to decode OAuth2 token: %s", err)
+	}
+	if resp.AccessToken == "" {
+		return "", 0, fmt.Errorf("no access token in OAuth2 token response")
+	}
+	if resp.TokenType != "" && !strings.EqualFold(resp.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported OAuth2 token type %q", resp.TokenType)
+	}
+	return resp.AccessToken, resp.ExpiresIn, nil
+}
+
+// Middleware returns an exporter middleware adding the access token as a bearer token to requests
+// to Typesense. A 401 response drops the token, so it is fetched again on the next request.
+func (s *oauth2TokenSource) Middleware() exporter.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &transportWithOAuth2{source: s, underlyingTransport: next}
+	}
+}
+
+type transportWithOAuth2 struct {
+	source              *oauth2TokenSource
+	underlyingTransport http.RoundTripper
+}
+
+func (t *transportWithOAuth2) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := t.underlyingTransport.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		t.source.invalidate(token)
+	}
+	return res, err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+type cachedAddrs struct {
+	addrs   []string
+	expires time.Time
+}
+
+// cachingResolver resolves hostnames and caches the results for ttl. When a lookup fails, the last
+// known addresses are used instead, masking short outages of the DNS server.
+type cachingResolver struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+
+	mtx   sync.Mutex
+	cache map[string]cachedAddrs
+}
+
+// newResolver creates a resolver querying server, or the system resolver when server is empty.
+func newResolver(server string, ttl time.Duration, dialer *net.Dialer) *cachingResolver {
+	resolver := net.DefaultResolver
+	if server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return &cachingResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		cache:    make(map[string]cachedAddrs),
+	}
+}
+
+// lookupHost returns the addresses of host.
+func (r *cachingResolver) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	r.mtx.Lock()
+	cached, ok := r.cache[host]
+	r.mtx.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		if ok {
+			return cached.addrs, nil
+		}
+		return nil, err
+	}
+
+	if r.ttl > 0 {
+		r.mtx.Lock()
+		r.cache[host] = cachedAddrs{addrs: addrs, expires: time.Now().Add(r.ttl)}
+		r.mtx.Unlock()
+	}
+	return addrs, nil
+}
+
+// dialContext resolves the host of addr and dials the resolved addresses in order until one
+// succeeds.
+func (r *cachingResolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := r.lookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, a := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(a, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	flag "github.com/namsral/flag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// labelFlags collects repeated key=value flags.
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("invalid label %q, must be name=value", s)
+	}
+	l[kv[0]] = kv[1]
+	return nil
+}
+
+// generateRules writes Prometheus recording and alerting rules for the exporter's metrics to w,
+// configured by args as passed to the generate-rules subcommand. There is no rule for API key
+// expiry, as the exporter doesn't collect the keys listed by /keys.
+func generateRules(w io.Writer, args []string) error {
+	var (
+		selector           string
+		forDuration        string
+		diskUsageThreshold float64
+		stateChanges       int
+	)
+	labels := labelFlags{}
+
+	fs := flag.NewFlagSet(name+" generate-rules", flag.ContinueOnError)
+	fs.StringVar(&selector, "selector", "", `label matchers added to every query, e.g. job="typesense"`)
+	fs.StringVar(&forDuration, "for", "5m", "how long a condition has to hold before alerting")
+	fs.Float64Var(&diskUsageThreshold, "disk-usage-threshold", 0.9, "ratio of used disk space to alert on")
+	fs.IntVar(&stateChanges, "state-changes", 3, "number of raft state changes of a Typesense node within 15 minutes to alert on")
+	fs.Var(labels, "label", "label name=value added to every alert, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// m returns the selector for metric with the extra matchers.
+	m := func(metric string, matchers ...string) string {
+		if selector != "" {
+			matchers = append(matchers, selector)
+		}
+		if len(matchers) == 0 {
+			return metric
+		}
+		return metric + "{" + strings.Join(matchers, ",") + "}"
+	}
+	alertLabels := func(severity string) map[string]string {
+		l := map[string]string{"severity": severity}
+		for k, v := range labels {
+			l[k] = v
+		}
+		return l
+	}
+
+	rules := ruleFile{Groups: []ruleGroup{
+		{
+			Name: "typesense.rules",
+			Rules: []rule{
+				{
+					Record: "typesense:cluster_metrics_system_disk_usage:ratio",
+					Expr:   m("typesense_cluster_metrics_system_disk_used_bytes") + " / " + m("typesense_cluster_metrics_system_disk_total_bytes"),
+				},
+				{
+					Record: "typesense:api_stats_requests_per_second:sum",
+					Expr:   "sum by (cluster) (" + m("typesense_api_stats_total_requests_per_second") + ")",
+				},
+			},
+		},
+		{
+			Name: "typesense.alerts",
+			Rules: []rule{
+				{
+					Alert:  "TypesenseScrapeFailing",
+					Expr:   m("typesense_exporter_scrape_success") + " == 0",
+					For:    forDuration,
+					Labels: alertLabels("critical"),
+					Annotations: map[string]string{
+						"summary":     "Typesense can't be scraped",
+						"description": "The {{ $labels.collector }} collector of {{ $labels.instance }} failed to scrape Typesense.",
+					},
+				},
+				{
+					Alert:  "TypesenseCredentialsInvalid",
+					Expr:   m("typesense_exporter_auth_valid") + " == 0",
+					For:    forDuration,
+					Labels: alertLabels("critical"),
+					Annotations: map[string]string{
+						"summary":     "Typesense rejects the exporter's credentials",
+						"description": "{{ $labels.target }} answered the exporter on {{ $labels.instance }} with 401 or 403; check the API key.",
+					},
+				},
+				{
+					Alert:  "TypesenseDiskNearlyFull",
+					Expr:   fmt.Sprintf("typesense:cluster_metrics_system_disk_usage:ratio > %g", diskUsageThreshold),
+					For:    forDuration,
+					Labels: alertLabels("warning"),
+					Annotations: map[string]string{
+						"summary":     "Typesense disk nearly full",
+						"description": "The disk of {{ $labels.cluster }} is {{ $value | humanizePercentage }} full.",
+					},
+				},
+				{
+					// Leader elections show up as the nodes changing between leader, candidate and
+					// follower.
+					Alert:  "TypesenseLeaderFlapping",
+					Expr:   fmt.Sprintf("changes(%s[15m]) > %d", m("typesense_server_state"), stateChanges),
+					Labels: alertLabels("warning"),
+					Annotations: map[string]string{
+						"summary":     "Typesense raft leadership is flapping",
+						"description": "{{ $labels.cluster }} changed its raft state {{ $value }} times in 15 minutes.",
+					},
+				},
+			},
+		},
+	}}
+
+	b, err := yaml.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
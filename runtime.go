@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// Paths of the memory limit for cgroup v2 and v1 respectively.
+var cgroupMemoryLimitPaths = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// setMaxProcs sets GOMAXPROCS to the container's CPU quota, unless GOMAXPROCS is set in the
+// environment.
+func setMaxProcs(logger *log.Logger) {
+	_, err := maxprocs.Set(maxprocs.Logger(func(format string, args ...interface{}) {
+		logger.Debugf(format, args...)
+	}))
+	if err != nil {
+		logger.WithError(err).Warnln("failed to set GOMAXPROCS from CPU quota")
+	}
+}
+
+// cgroupMemoryLimit returns the memory limit of the container in bytes, or false when the
+// exporter isn't running in a memory-limited cgroup.
+func cgroupMemoryLimit() (int64, bool) {
+	for _, path := range cgroupMemoryLimitPaths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			// cgroup v2 reports "max" when unlimited.
+			return 0, false
+		}
+		// cgroup v1 reports a page-aligned maximum int64 when unlimited.
+		if limit <= 0 || limit >= 1<<62 {
+			return 0, false
+		}
+		return limit, true
+	}
+	return 0, false
+}
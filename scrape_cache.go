@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	collector "github.com/scraton/typesense_exporter/collector"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// scrapeCache scrapes Typesense in the background every interval and serves the metrics of the
+// last scrape, so scrapes of the exporter don't wait on Typesense and several Prometheus servers
+// don't multiply the load on it.
+type scrapeCache struct {
+	logger *log.Logger
+	// collectors holds a collector for each enabled collector name, so their results are cached
+	// separately.
+	collectors map[string]*collector.TypesenseCollector
+	interval   time.Duration
+	// intervals overrides interval for the collectors it names, which then scrape on their own
+	// schedule.
+	intervals map[string]time.Duration
+	// timestamps attaches the time of the background scrape to the metrics served from the cache.
+	timestamps bool
+	// maxAge is how old cached results may get before they are dropped and their collector is
+	// reported down. Until then, the last successful results of a failing collector keep being
+	// served. 0 serves failures right away and never drops results.
+	maxAge time.Duration
+	// active reports whether to scrape, e.g. only while holding the leader election Lease.
+	active func() bool
+	// jitter spreads the scrapes of the collectors over the interval, each at a fixed offset derived
+	// from its target, rather than scraping with all of them at once.
+	jitter bool
+
+	mtx     sync.RWMutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry holds the results of the last background scrape of a collector.
+type cacheEntry struct {
+	metrics     []prometheus.Metric
+	collectedAt time.Time
+	succeeded   bool
+}
+
+func newScrapeCache(logger *log.Logger, collectors map[string]*collector.TypesenseCollector, interval time.Duration) *scrapeCache {
+	return &scrapeCache{
+		logger:     logger,
+		collectors: collectors,
+		interval:   interval,
+		entries:    make(map[string]*cacheEntry, len(collectors)),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *scrapeCache) Describe(ch chan<- *prometheus.Desc) {
+	for _, tc := range c.collectors {
+		tc.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface, sending the metrics of the last
+// background scrape. Results older than the max age, e.g. of a collector whose scrapes hang or
+// stopped, are replaced by its up gauge and scrape success at 0.
+func (c *scrapeCache) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for name, entry := range c.entries {
+		if c.maxAge > 0 && time.Since(entry.collectedAt) > c.maxAge {
+			for _, m := range c.collectors[name].StaleResult(name) {
+				ch <- m
+			}
+			continue
+		}
+		for _, m := range entry.metrics {
+			if c.timestamps {
+				m = prometheus.NewMetricWithTimestamp(entry.collectedAt, m)
+			}
+			ch <- m
+		}
+	}
+}
+
+// intervalOf returns the interval at which the named collector scrapes.
+func (c *scrapeCache) intervalOf(name string) time.Duration {
+	if interval, ok := c.intervals[name]; ok {
+		return interval
+	}
+	return c.interval
+}
+
+// Run scrapes Typesense right away and then every interval until ctx is done. With jitter, each
+// collector first waits for its offset. With per-collector intervals, each collector scrapes on
+// its own schedule.
+func (c *scrapeCache) Run(ctx context.Context) {
+	if c.jitter || len(c.intervals) > 0 {
+		var wg sync.WaitGroup
+		for name, tc := range c.collectors {
+			wg.Add(1)
+			go func(name string, tc *collector.TypesenseCollector) {
+				defer wg.Done()
+				c.runCollector(ctx, name, tc)
+			}(name, tc)
+		}
+		wg.Wait()
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if c.active == nil || c.active() {
+			c.refresh()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runCollector scrapes Typesense with the named collector at its interval until ctx is done,
+// starting right away or, with jitter, at its offset within the interval.
+func (c *scrapeCache) runCollector(ctx context.Context, name string, tc *collector.TypesenseCollector) {
+	interval := c.intervalOf(name)
+	if c.jitter {
+		offset := c.offset(name, tc)
+		c.logger.WithFields(log.Fields{"name": name, "offset": offset}).Debugln("scheduled jittered background scrapes")
+
+		timer := time.NewTimer(offset)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if c.active == nil || c.active() {
+			c.refreshCollector(name, tc)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// offset returns the fixed offset within its interval at which the named collector scrapes, derived
+// from the Typesense endpoint it scrapes so that different targets are spread out.
+func (c *scrapeCache) offset(name string, tc *collector.TypesenseCollector) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	if t, ok := tc.Collectors[name].(collector.TargetReporter); ok {
+		h.Write([]byte(t.Target().ScrapeURL))
+	}
+	return time.Duration(h.Sum64() % uint64(c.intervalOf(name)))
+}
+
+// refresh scrapes Typesense with every collector, giving up on collectors which don't finish
+// within the interval.
+func (c *scrapeCache) refresh() {
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for name, tc := range c.collectors {
+		wg.Add(1)
+		go func(name string, tc *collector.TypesenseCollector) {
+			defer wg.Done()
+			c.refreshCollector(name, tc)
+		}(name, tc)
+	}
+	wg.Wait()
+
+	c.logger.WithField("duration", time.Since(start)).Debugln("refreshed scrape cache")
+}
+
+// refreshCollector scrapes Typesense with the named collector, giving up if it doesn't finish
+// within its interval.
+func (c *scrapeCache) refreshCollector(name string, tc *collector.TypesenseCollector) {
+	start := time.Now()
+	deadline := start.Add(c.intervalOf(name))
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		tc.WithDeadline(deadline).Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	c.update(name, &cacheEntry{
+		metrics:     metrics,
+		collectedAt: start,
+		succeeded:   scrapeSucceeded(tc, name, start),
+	})
+}
+
+// update replaces the cached results of the named collector with entry, unless the scrape failed
+// and the last successful results are younger than the max age.
+func (c *scrapeCache) update(name string, entry *cacheEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	prev, ok := c.entries[name]
+	if !entry.succeeded && ok && prev.succeeded && entry.collectedAt.Sub(prev.collectedAt) < c.maxAge {
+		c.logger.WithFields(log.Fields{
+			"name": name,
+			"age":  entry.collectedAt.Sub(prev.collectedAt),
+		}).Debugln("collector failed, serving its last successful results")
+		return
+	}
+	c.entries[name] = entry
+}
+
+// scrapeSucceeded reports whether the named collector of tc finished a successful scrape since
+// start. Collectors which don't report their target state are assumed to succeed.
+func scrapeSucceeded(tc *collector.TypesenseCollector, name string, start time.Time) bool {
+	t, ok := tc.Collectors[name].(collector.TargetReporter)
+	if !ok {
+		return true
+	}
+	target := t.Target()
+	return target.Health == "up" && !target.LastScrape.Before(start)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	exporter "github.com/scraton/typesense_exporter/exporter"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// targetExporters creates and caches an exporter for each Typesense node requested with ?target=,
+// sharing the API key, transport and other options of the configured exporter. Targets must match
+// pattern, so the API key isn't sent to arbitrary hosts. Exporters of targets which weren't scraped
+// for idleTimeout are dropped, so targets removed from service discovery don't linger.
+type targetExporters struct {
+	pattern     *regexp.Regexp
+	scheme      string
+	opts        []exporter.Option
+	idleTimeout time.Duration
+	// onEvict, if set, is called with each target whose exporter is dropped.
+	onEvict func(target string)
+
+	mtx       sync.Mutex
+	exporters map[string]*exporter.Exporter
+	lastUsed  map[string]time.Time
+}
+
+func newTargetExporters(pattern, scheme string, idleTimeout time.Duration, opts []exporter.Option) (*targetExporters, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &targetExporters{
+		pattern:     re,
+		scheme:      scheme,
+		opts:        opts,
+		idleTimeout: idleTimeout,
+		exporters:   make(map[string]*exporter.Exporter),
+		lastUsed:    make(map[string]time.Time),
+	}, nil
+}
+
+// get returns the exporter for target, a host:port or URL. Targets without a scheme use the scheme
+// of the configured Typesense URL.
+func (t *targetExporters) get(target string) (*exporter.Exporter, error) {
+	if !t.pattern.MatchString(target) {
+		return nil, fmt.Errorf("target %q not allowed", target)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := time.Now()
+	t.evict(now)
+	if e, ok := t.exporters[target]; ok {
+		t.lastUsed[target] = now
+		return e, nil
+	}
+
+	rawURL := target
+	if !strings.Contains(target, "://") {
+		rawURL = t.scheme + "://" + target
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid target %q", target)
+	}
+
+	// The target's collectors are registered with the per-scrape registry by the handler, so they
+	// are only collected for scrapes of that target.
+	opts := append(append([]exporter.Option(nil), t.opts...),
+		exporter.WithURL(u.String()),
+		exporter.WithRegisterer(prometheus.NewRegistry()),
+	)
+	e, err := exporter.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.exporters[target] = e
+	t.lastUsed[target] = now
+	return e, nil
+}
+
+// evict closes and drops the exporters of targets which weren't scraped for the idle timeout.
+func (t *targetExporters) evict(now time.Time) {
+	if t.idleTimeout <= 0 {
+		return
+	}
+	for target, e := range t.exporters {
+		if now.Sub(t.lastUsed[target]) > t.idleTimeout {
+			e.Close()
+			delete(t.exporters, target)
+			delete(t.lastUsed, target)
+			if t.onEvict != nil {
+				t.onEvict(target)
+			}
+		}
+	}
+}
+
+// all returns the exporters created so far, ordered by target.
+func (t *targetExporters) all() []*exporter.Exporter {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	targets := make([]string, 0, len(t.exporters))
+	for target := range t.exporters {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	exporters := make([]*exporter.Exporter, 0, len(targets))
+	for _, target := range targets {
+		exporters = append(exporters, t.exporters[target])
+	}
+	return exporters
+}
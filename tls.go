@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader serves the web TLS certificate, reloading it from disk when the certificate or key
+// file changes, so rotated certificates are picked up without restarting the exporter.
+type certReloader struct {
+	logger   *log.Logger
+	certFile string
+	keyFile  string
+
+	mtx     sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader creates a certReloader, failing if the certificate can't be loaded initially.
+func newCertReloader(logger *log.Logger, certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	return r, r.reload()
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.cert, nil
+}
+
+// Run checks the files for changes every interval and reloads the certificate when they changed or
+// a signal is received on reload, until ctx is done. A certificate failing to load is logged and
+// the previous one kept.
+func (r *certReloader) Run(ctx context.Context, interval time.Duration, reload <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		case <-reload:
+		}
+
+		if err := r.reload(); err != nil {
+			r.logger.WithError(err).Errorln("failed to reload TLS certificate")
+			continue
+		}
+		r.logger.WithField("cert", r.certFile).Infoln("reloaded TLS certificate")
+	}
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	// Remember the files were seen even if they failed to load, to retry once they change again
+	// rather than on every check.
+	r.modTime = modTime
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	return nil
+}
+
+func (r *certReloader) changed() bool {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		// Files are briefly missing while being replaced, check again on the next tick.
+		return false
+	}
+
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return !modTime.Equal(r.modTime)
+}
+
+// latestModTime returns the modification time of the most recently changed of the two files.
+// Stat follows symlinks, so the atomic symlink swaps of Kubernetes secret volumes are seen too.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
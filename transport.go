@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	http2 "golang.org/x/net/http2"
+)
+
+// transportConfig holds the flags tuning the transport used for requests to Typesense.
+type transportConfig struct {
+	connectTimeout      time.Duration
+	disableCompression  bool
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	tlsHandshakeTimeout time.Duration
+	dnsServer           string
+	dnsCacheTTL         time.Duration
+	// ipProtocol restricts dialing to ip4 or ip6 addresses, any dials both.
+	ipProtocol string
+
+	// h2c speaks HTTP/2 over cleartext connections to http:// URLs, without negotiating it first.
+	// https:// URLs negotiate HTTP/2 as usual.
+	h2c bool
+}
+
+// newTransport creates the transport used for requests to Typesense.
+func newTransport(config transportConfig) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   config.connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	dialContext := dialer.DialContext
+	if config.dnsServer != "" || config.dnsCacheTTL > 0 {
+		dialContext = newResolver(config.dnsServer, config.dnsCacheTTL, dialer).dialContext(dialer)
+	}
+	if config.ipProtocol == "ip4" || config.ipProtocol == "ip6" {
+		tcpNetwork := "tcp" + strings.TrimPrefix(config.ipProtocol, "ip")
+		dial := dialContext
+		dialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, tcpNetwork, addr)
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: config.maxIdleConnsPerHost,
+		IdleConnTimeout:     config.idleConnTimeout,
+		TLSHandshakeTimeout: config.tlsHandshakeTimeout,
+		// With compression enabled, the transport asks for gzip and transparently decompresses.
+		DisableCompression: config.disableCompression,
+	}
+	if !config.h2c {
+		return transport
+	}
+
+	// The transport is shared by clusters of different schemes, so the scheme of each request
+	// decides whether it goes over h2c.
+	return &h2cTransport{
+		h2c: &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: config.disableCompression,
+			ReadIdleTimeout:    config.idleConnTimeout,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialContext(context.Background(), network, addr)
+			},
+		},
+		next: transport,
+	}
+}
+
+// h2cTransport sends requests to http:// URLs over h2c and all others through next.
+type h2cTransport struct {
+	h2c  http.RoundTripper
+	next http.RoundTripper
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
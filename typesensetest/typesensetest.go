@@ -0,0 +1,193 @@
+// Package typesensetest provides a fake Typesense server for testing programs which embed the
+// collectors, without needing a real cluster.
+package typesensetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+// APIKeyHeader is the header Typesense reads the API key from.
+const APIKeyHeader = "X-Typesense-API-Key"
+
+// Default payloads served until overridden, modeled after a single idle Typesense node.
+const (
+	DefaultStats = `{
+  "delete_latency_ms": 0,
+  "delete_requests_per_second": 0,
+  "import_latency_ms": 0,
+  "import_requests_per_second": 0,
+  "latency_ms": {
+    "GET /collections/products/documents/search": 2.5
+  },
+  "pending_write_batches": 0,
+  "requests_per_second": {
+    "GET /collections/products/documents/search": 1.5
+  },
+  "search_latency_ms": 2.5,
+  "search_requests_per_second": 1.5,
+  "total_requests_per_second": 1.5,
+  "write_latency_ms": 0,
+  "write_requests_per_second": 0
+}`
+	DefaultMetrics = `{
+  "system_cpu1_active_percentage": "2.00",
+  "system_cpu_active_percentage": "2.00",
+  "system_disk_total_bytes": "102888095744",
+  "system_disk_used_bytes": "4177268736",
+  "system_memory_total_bytes": "2084536320",
+  "system_memory_used_bytes": "706527232",
+  "system_network_received_bytes": "4140544",
+  "system_network_sent_bytes": "1265664",
+  "typesense_memory_active_bytes": "16027648",
+  "typesense_memory_allocated_bytes": "12547664",
+  "typesense_memory_fragmentation_ratio": "0.22",
+  "typesense_memory_mapped_bytes": "72941568",
+  "typesense_memory_metadata_bytes": "5374016",
+  "typesense_memory_resident_bytes": "16027648",
+  "typesense_memory_retained_bytes": "30015488"
+}`
+	DefaultCollections = `[]`
+	DefaultDebug       = `{"state": 1, "version": "0.23.1"}`
+	DefaultHealth      = `{"ok": true}`
+)
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Server is a fake Typesense node serving canned payloads.
+type Server struct {
+	*httptest.Server
+
+	// APIKey is the key requests have to present, any key is accepted when empty.
+	APIKey string
+	// Paginate makes JSON array responses honor the limit and offset query parameters, like
+	// /collections of Typesense releases supporting pagination. Otherwise they are ignored.
+	Paginate bool
+
+	mtx       sync.RWMutex
+	responses map[string]response
+	requests  map[string]int
+}
+
+// NewServer starts a fake Typesense node serving the default payloads. Callers should call Close
+// when finished to shut it down.
+func NewServer(apiKey string) *Server {
+	s := NewUnstartedServer(apiKey)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a fake Typesense node which is not started yet, so that payloads or
+// the underlying httptest.Server can be configured first.
+func NewUnstartedServer(apiKey string) *Server {
+	s := &Server{
+		APIKey:    apiKey,
+		responses: make(map[string]response),
+		requests:  make(map[string]int),
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+
+	s.SetResponse("/stats.json", http.StatusOK, DefaultStats)
+	s.SetResponse("/metrics.json", http.StatusOK, DefaultMetrics)
+	s.SetResponse("/collections", http.StatusOK, DefaultCollections)
+	s.SetResponse("/debug", http.StatusOK, DefaultDebug)
+	s.SetResponse("/health", http.StatusOK, DefaultHealth)
+
+	return s
+}
+
+// SetResponse serves body with status for requests to path.
+func (s *Server) SetResponse(path string, status int, body string) {
+	s.SetResponseHeader(path, status, nil, body)
+}
+
+// SetResponseHeader serves body with status and the headers in header for requests to path, e.g. a
+// 429 with Retry-After.
+func (s *Server) SetResponseHeader(path string, status int, header http.Header, body string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.responses[path] = response{status: status, header: header, body: []byte(body)}
+}
+
+// SetJSON serves v encoded as JSON for requests to path.
+func (s *Server) SetJSON(path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.SetResponse(path, http.StatusOK, string(body))
+	return nil
+}
+
+// RemoveResponse makes requests to path return 404, like endpoints missing on older versions.
+func (s *Server) RemoveResponse(path string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.responses, path)
+}
+
+// Requests returns how many requests were made to path.
+func (s *Server) Requests(path string) int {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.requests[path]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	s.requests[r.URL.Path]++
+	res, ok := s.responses[r.URL.Path]
+	s.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	for key, values := range res.header {
+		w.Header()[key] = values
+	}
+	if ok && s.Paginate && res.status == http.StatusOK {
+		res.body = page(res.body, r.URL.Query().Get("limit"), r.URL.Query().Get("offset"))
+	}
+	switch {
+	case s.APIKey != "" && r.Header.Get(APIKeyHeader) != s.APIKey:
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message": "Forbidden - a valid ` + "`x-typesense-api-key`" + ` header must be sent."}`))
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+	default:
+		w.WriteHeader(res.status)
+		_, _ = w.Write(res.body)
+	}
+}
+
+// page returns the items of the JSON array body from offset, at most limit of them. Bodies which
+// aren't arrays and empty or invalid parameters are left alone.
+func page(body []byte, limit, offset string) []byte {
+	var items []json.RawMessage
+	if (limit == "" && offset == "") || json.Unmarshal(body, &items) != nil {
+		return body
+	}
+	if n, err := strconv.Atoi(offset); err == nil && n > 0 {
+		if n > len(items) {
+			n = len(items)
+		}
+		items = items[n:]
+	}
+	if n, err := strconv.Atoi(limit); err == nil && n >= 0 && n < len(items) {
+		items = items[:n]
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return body
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	exporter "github.com/scraton/typesense_exporter/exporter"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// upstreamLimiter caps the requests to Typesense in flight at once across all collectors and
+// targets. A request holds its slot until its response body is closed.
+type upstreamLimiter struct {
+	slots chan struct{}
+
+	inFlight prometheus.Gauge
+	delayed  prometheus.Counter
+}
+
+func newUpstreamLimiter(n int) *upstreamLimiter {
+	return &upstreamLimiter{
+		slots: make(chan struct{}, n),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(name, "", "upstream_requests_in_flight"),
+			Help: "Number of requests to Typesense in flight, limited by max-upstream-concurrency",
+		}),
+		delayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(name, "", "upstream_requests_delayed_total"),
+			Help: "Number of requests to Typesense which had to wait because max-upstream-concurrency requests were in flight",
+		}),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (l *upstreamLimiter) Describe(ch chan<- *prometheus.Desc) {
+	l.inFlight.Describe(ch)
+	l.delayed.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (l *upstreamLimiter) Collect(ch chan<- prometheus.Metric) {
+	l.inFlight.Collect(ch)
+	l.delayed.Collect(ch)
+}
+
+// Middleware returns an exporter.Middleware holding requests back while the limit is reached.
+func (l *upstreamLimiter) Middleware() exporter.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &transportWithLimit{limiter: l, underlyingTransport: next}
+	}
+}
+
+func (l *upstreamLimiter) release() {
+	l.inFlight.Dec()
+	<-l.slots
+}
+
+type transportWithLimit struct {
+	limiter             *upstreamLimiter
+	underlyingTransport http.RoundTripper
+}
+
+func (t *transportWithLimit) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.limiter.slots <- struct{}{}:
+	default:
+		t.limiter.delayed.Inc()
+		select {
+		case t.limiter.slots <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	t.limiter.inFlight.Inc()
+
+	res, err := t.underlyingTransport.RoundTrip(req)
+	if err != nil {
+		t.limiter.release()
+		return nil, err
+	}
+	res.Body = &limitedBody{ReadCloser: res.Body, release: t.limiter.release}
+	return res, nil
+}
+
+// limitedBody releases the slot of its request once closed.
+type limitedBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
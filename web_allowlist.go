@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// webAllowlist only serves requests from clients within its networks, answering others with 403.
+// The client address is the connection's remote address; forwarding headers aren't trusted.
+type webAllowlist struct {
+	networks []*net.IPNet
+
+	// exempt paths are served to every client, e.g. for liveness probes.
+	exempt map[string]bool
+}
+
+// newWebAllowlist parses a comma-separated list of CIDRs. Bare IP addresses allow that address
+// alone.
+func newWebAllowlist(cidrs string) (*webAllowlist, error) {
+	a := &webAllowlist{}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			a.networks = append(a.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		a.networks = append(a.networks, network)
+	}
+	if len(a.networks) == 0 {
+		return nil, fmt.Errorf("no networks in allowlist")
+	}
+	return a, nil
+}
+
+func (a *webAllowlist) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler wraps next, answering requests from clients outside the allowlist with 403.
+func (a *webAllowlist) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.exempt[r.URL.Path] && !a.allowed(r.RemoteAddr) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer serves an OIDC discovery document and JWKS with an RSA key "rsa" and an EC key "ec".
+type testIssuer struct {
+	server *httptest.Server
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	// issuer is the issuer announced in the discovery document, the server URL if empty.
+	issuer string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsa: rsaKey, ec: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := iss.issuer
+		if issuer == "" {
+			issuer = iss.server.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": iss.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{
+			{Kty: "RSA", Kid: "rsa", Use: "sig", N: enc(rsaKey.N.Bytes()), E: enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kty: "EC", Kid: "ec", Crv: "P-256", X: enc(ecKey.X.Bytes()), Y: enc(ecKey.Y.Bytes())},
+		}})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+// sign returns a JWT with the header and claims, signed with the key named by signer.
+func (iss *testIssuer) sign(t *testing.T, header, claims map[string]interface{}, signer string) string {
+	part := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := part(header) + "." + part(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch signer {
+	case "rsa":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ec":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerifierVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	now := time.Now().Unix()
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{"iss": iss.server.URL, "aud": "exporter", "exp": now + 300}
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr string
+	}{
+		{
+			name: "valid RSA",
+			token: func() string {
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, validClaims(), "rsa")
+			},
+		},
+		{
+			name: "valid EC",
+			token: func() string {
+				return iss.sign(t, map[string]interface{}{"alg": "ES256", "kid": "ec"}, validClaims(), "ec")
+			},
+		},
+		{
+			name: "audience in list",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = []string{"other", "exporter"}
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+		},
+		{
+			name: "bad signature",
+			token: func() string {
+				token := iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, validClaims(), "rsa")
+				parts := strings.Split(token, ".")
+				tampered := iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, map[string]interface{}{"iss": iss.server.URL, "aud": "other", "exp": now + 300}, "rsa")
+				return strings.Join([]string{parts[0], strings.Split(tampered, ".")[1], parts[2]}, ".")
+			},
+			wantErr: "verification error",
+		},
+		{
+			name: "signed by another key",
+			token: func() string {
+				return iss.sign(t, map[string]interface{}{"alg": "ES256", "kid": "rsa"}, validClaims(), "ec")
+			},
+			wantErr: "doesn't match RSA key",
+		},
+		{
+			name: "RSA algorithm with EC key",
+			token: func() string {
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "ec"}, validClaims(), "rsa")
+			},
+			wantErr: "doesn't match EC key",
+		},
+		{
+			name: "unsupported algorithm",
+			token: func() string {
+				return iss.sign(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, validClaims(), "rsa")
+			},
+			wantErr: "unsupported JWT algorithm",
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := validClaims()
+				claims["iss"] = "https://accounts.example.com"
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+			wantErr: "unexpected issuer",
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "other"
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+			wantErr: "audience doesn't contain",
+		},
+		{
+			name: "missing audience",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "aud")
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+			wantErr: "audience doesn't contain",
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims()
+				claims["exp"] = now - 3600
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+			wantErr: "token expired",
+		},
+		{
+			name: "without expiry",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "exp")
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+			wantErr: "token expired",
+		},
+		{
+			name: "not valid yet",
+			token: func() string {
+				claims := validClaims()
+				claims["nbf"] = now + 3600
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+			wantErr: "token not valid yet",
+		},
+		{
+			name: "not before within leeway",
+			token: func() string {
+				claims := validClaims()
+				claims["nbf"] = now + 10
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims, "rsa")
+			},
+		},
+		{
+			name: "unknown key ID",
+			token: func() string {
+				return iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "gone"}, validClaims(), "rsa")
+			},
+			wantErr: "unknown signing key",
+		},
+		{
+			name:    "malformed",
+			token:   func() string { return "not-a-jwt" },
+			wantErr: "malformed JWT",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			v := newOIDCVerifier(iss.server.URL, "exporter", time.Second)
+			err := v.Verify(context.Background(), tt.token())
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("expected error containing %q, got none", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("expected error containing %q, got %q", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierDiscoveryIssuerMismatch(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.issuer = "https://accounts.example.com"
+
+	v := newOIDCVerifier(iss.server.URL, "exporter", time.Second)
+	token := iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, map[string]interface{}{
+		"iss": iss.server.URL, "aud": "exporter", "exp": time.Now().Unix() + 300,
+	}, "rsa")
+	err := v.Verify(context.Background(), token)
+	if err == nil || !strings.Contains(err.Error(), "is for issuer") {
+		t.Fatalf("expected discovery issuer mismatch, got %v", err)
+	}
+}
+
+func TestOIDCVerifierRefetchLimit(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := newOIDCVerifier(iss.server.URL, "exporter", time.Second)
+	token := iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "rsa"}, map[string]interface{}{
+		"iss": iss.server.URL, "aud": "exporter", "exp": time.Now().Unix() + 300,
+	}, "rsa")
+	if err := v.Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys were just fetched, so an unknown key ID fails without asking the issuer again.
+	iss.server.Close()
+	unknown := iss.sign(t, map[string]interface{}{"alg": "RS256", "kid": "new"}, map[string]interface{}{
+		"iss": iss.server.URL, "aud": "exporter", "exp": time.Now().Unix() + 300,
+	}, "rsa")
+	err := v.Verify(context.Background(), unknown)
+	if err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("expected unknown signing key, got %v", err)
+	}
+}